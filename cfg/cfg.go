@@ -1,6 +1,9 @@
 package cfg
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 // The constants be used by both client and server
 var (
@@ -13,6 +16,7 @@ var (
 	QueryCacheDuration    = "cache_duration"
 	QueryCacheStatusCode  = "cache_status_code"
 	QueryCacheContentType = "cache_content_type"
+	QueryCachePrefix      = "cache_prefix"
 	RequestCacheTimeout   = 5 * time.Second
 )
 
@@ -20,6 +24,82 @@ var (
 // used inside nethttp and fhttp Skippers.
 var NoCacheHeader = "X-No-Cache"
 
+// CacheStatusHeader is the default header key which is setted to the response
+// with a value of CacheStatusHit, CacheStatusMiss or CacheStatusStale,
+// used inside nethttp and fhttp handlers when their CacheStatusHeader is enabled.
+var (
+	CacheStatusHeader = "X-Cache"
+	CacheStatusHit    = "HIT"
+	CacheStatusMiss   = "MISS"
+	CacheStatusStale  = "STALE"
+)
+
+// StaleWarning is the "Warning" header's value of a stale response,
+// which is served because the original handler failed, see stale-if-error.
+var StaleWarning = `110 - "Response is Stale"`
+
+// The span names and attribute keys, used by the nethttp and fhttp handlers
+// when a tracer is given to them.
+var (
+	OriginSpanName      = "httpcache.origin"
+	RemoteGetSpanName   = "httpcache.remote.get"
+	RemotePostSpanName  = "httpcache.remote.post"
+	SpanKeyAttribute    = "httpcache.key"
+	SpanHitAttribute    = "httpcache.hit"
+	SpanStoredAttribute = "httpcache.stored"
+)
+
+// StoreErrorPolicy is the behavior of the client handlers when the remote cache server
+// can't be reached, see their OnStoreError.
+type StoreErrorPolicy uint8
+
+const (
+	// FailOpen executes the original handler, a cache outage never breaks the serving, the default.
+	FailOpen StoreErrorPolicy = iota
+	// FailClosed responds with the StoreUnavailableStatus instead,
+	// this way a cache outage doesn't overload the original handler.
+	FailClosed
+)
+
+// StoreUnavailableStatus is the status code of the FailClosed responses.
+var StoreUnavailableStatus = 503
+
+// Logger is the interface of the optional logger of the handlers and of the remote cache server,
+// it reports the cache hits, misses, stores and the remote cache server's errors.
+// The standard library's *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// NopLogger is the default Logger, it discards everything.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// disabled is 1 when the caching is disabled for all the handlers,
+// it's accessed atomically, see SetEnabled.
+var disabled uint32
+
+// SetEnabled enables or disables the caching of all the nethttp and fhttp handlers at runtime,
+// a disabled handler executes the original handler, nothing is served from or stored to the cache,
+// the existing entries are kept. It's safe for concurrent use.
+//
+// The caching is enabled by default.
+func SetEnabled(enabled bool) {
+	var v uint32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&disabled, v)
+}
+
+// Enabled reports whether the caching is enabled, see SetEnabled.
+func Enabled() bool {
+	return atomic.LoadUint32(&disabled) == 0
+}
+
 // MinimumCacheDuration is the minimum duration from time.Now
 // which is allowed between cache save and cache clear
 var MinimumCacheDuration = 2 * time.Second
@@ -0,0 +1,41 @@
+// Package chi provides a go-chi/chi middleware which caches the responses
+// of the next handlers, it's the chi's equivalent of the httpcache.Cache.
+//
+// Example:
+//
+//	r := chi.NewRouter()
+//	r.Use(httpcachechi.Cache(20 * time.Second))
+//	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+//		w.Write([]byte("cached for 20 seconds"))
+//	})
+//	http.ListenAndServe(":8080", r)
+package chi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/geekypanda/httpcache/nethttp"
+	"github.com/geekypanda/httpcache/nethttp/rule"
+)
+
+// Cache returns a chi middleware which caches the next handler's response,
+// each route which uses it keeps its own cache entries, one per request method, path and query.
+// The parameter is, optional, the cache Entry's expiration duration
+// if the expiration <=2 seconds then expiration is taken by the "cache-control's maxage" header.
+//
+// It records the response under the writer it receives,
+// so it works with the chi's middleware.WrapResponseWriter
+// (i.e middleware.Logger) as an outer or an inner middleware.
+//
+// Optional rules are executed after the nethttp.DefaultRuleSet,
+// use them to attach claim and valid predicates, i.e rule.Validator.
+func Cache(expiration time.Duration, rules ...rule.Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := nethttp.NewHandler(next, expiration)
+		for _, r := range rules {
+			h.AddRule(r)
+		}
+		return h
+	}
+}
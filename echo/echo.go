@@ -0,0 +1,67 @@
+// Package echo provides a labstack/echo middleware which caches the responses
+// of the next handlers, it's the echo's equivalent of the httpcache.Cache.
+//
+// Example:
+//
+//	e := echo.New()
+//	e.GET("/", func(c echo.Context) error {
+//		return c.String(http.StatusOK, "cached for 20 seconds")
+//	}, httpcacheecho.Middleware(20*time.Second))
+//	e.Start(":8080")
+package echo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/geekypanda/httpcache/nethttp"
+	"github.com/geekypanda/httpcache/nethttp/rule"
+	labstack "github.com/labstack/echo"
+)
+
+// call is the echo context of a request and the next handler's error,
+// it's passed to the cached handler through the request's context.
+type call struct {
+	c   labstack.Context
+	err error
+}
+
+// callKey is the request's context key of its call.
+type callKey struct{}
+
+// Middleware returns an echo middleware which caches the next handler's response,
+// each route which uses it keeps its own cache entries, one per request method, path and query,
+// it's a nethttp.Handler so it follows the same rules as the httpcache.Cache.
+// The second parameter is, optional, the cache Entry's expiration duration
+// if the expiration <=2 seconds then expiration is taken by the "cache-control's maxage" header.
+//
+// On a cache hit the next handler is not executed at all.
+//
+// Optional rules are executed after the nethttp.DefaultRuleSet,
+// use them to attach claim and valid predicates, i.e rule.Validator.
+func Middleware(expiration time.Duration, rules ...rule.Rule) labstack.MiddlewareFunc {
+	return func(next labstack.HandlerFunc) labstack.HandlerFunc {
+		h := nethttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			call := r.Context().Value(callKey{}).(*call)
+			// the next handler writes to the handler's recorder.
+			res := call.c.Response()
+			underline := res.Writer
+			res.Writer = w
+			call.c.SetRequest(r)
+			call.err = next(call.c)
+			res.Writer = underline
+		}), expiration)
+		for _, r := range rules {
+			h.AddRule(r)
+		}
+
+		return func(c labstack.Context) error {
+			req := c.Request()
+			call := &call{c: c}
+			h.ServeHTTP(c.Response().Writer, req.WithContext(context.WithValue(req.Context(), callKey{}, call)))
+			c.SetRequest(req)
+			return call.err
+		}
+	}
+}
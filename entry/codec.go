@@ -0,0 +1,57 @@
+package entry
+
+import (
+	"encoding/json"
+)
+
+// Codec encodes and decodes the entries,
+// it's used by the stores which keep the entries outside of the memory.
+type Codec interface {
+	// Encode returns the encoded form of the entry.
+	Encode(e *Entry) ([]byte, error)
+	// Decode returns the entry of an encoded form, which has been returned by Encode.
+	Decode(data []byte) (*Entry, error)
+}
+
+type (
+	// GobCodec is the Codec which encodes the entries with the encoding/gob,
+	// see Entry.MarshalBinary.
+	GobCodec struct{}
+	// JSONCodec is the Codec which encodes the entries as JSON objects,
+	// human-readable but bigger than the GobCodec's.
+	JSONCodec struct{}
+)
+
+// DefaultCodec is the default Codec of the stores, the GobCodec.
+var DefaultCodec Codec = GobCodec{}
+
+// Encode returns the gob-encoded entry.
+func (GobCodec) Encode(e *Entry) ([]byte, error) {
+	return e.MarshalBinary()
+}
+
+// Decode returns the entry of a gob-encoded form.
+func (GobCodec) Decode(data []byte) (*Entry, error) {
+	e := &Entry{}
+	if err := e.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Encode returns the JSON-encoded entry.
+func (JSONCodec) Encode(e *Entry) ([]byte, error) {
+	return json.Marshal(e.snapshot())
+}
+
+// Decode returns the entry of a JSON-encoded form.
+func (JSONCodec) Decode(data []byte) (*Entry, error) {
+	var s entrySnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	e := &Entry{}
+	e.restore(s)
+	return e, nil
+}
@@ -1,6 +1,8 @@
 package entry
 
 import (
+	"bytes"
+	"encoding/gob"
 	"time"
 
 	"github.com/geekypanda/httpcache/cfg"
@@ -10,8 +12,17 @@ import (
 // contains the expiration datetime and the response
 type Entry struct {
 	life time.Duration
+	// minimum is the minimum life duration of this entry,
+	// defaults to the cfg.MinimumCacheDuration
+	minimum time.Duration
 	// ExpiresAt is the time which this cache will not be available
 	expiresAt time.Time
+	// staleIfError is the duration after the expiresAt
+	// which the response can still be served if the original handler fails.
+	staleIfError time.Duration
+	// mustRevalidate is true when the response must not be served stale,
+	// see MustRevalidate.
+	mustRevalidate bool
 
 	// Response the response should be served to the client
 	response *Response
@@ -24,14 +35,25 @@ type Entry struct {
 // it doesn't sets the expiresAt & the response
 // because these are setting each time on Reset
 func NewEntry(duration time.Duration) *Entry {
+	return NewEntryMinimum(duration, cfg.MinimumCacheDuration)
+}
+
+// NewEntryMinimum same as NewEntry but it accepts a custom
+// minimum life duration instead of the cfg.MinimumCacheDuration,
+// a zero "minimum" means that the given "duration" is honored as it's, i.e one second.
+func NewEntryMinimum(duration time.Duration, minimum time.Duration) *Entry {
+	if minimum < 0 {
+		minimum = 0
+	}
 	// if given duration is not <=0 (which means finds from the headers)
-	// then we should check for the MinimumCacheDuration here
-	if duration >= 0 && duration < cfg.MinimumCacheDuration {
-		duration = cfg.MinimumCacheDuration
+	// then we should check for the minimum here
+	if duration >= 0 && duration < minimum {
+		duration = minimum
 	}
 
 	return &Entry{
 		life:     duration,
+		minimum:  minimum,
 		response: &Response{},
 	}
 }
@@ -47,12 +69,67 @@ func (e *Entry) Response() (*Response, bool) {
 	return e.response, true
 }
 
+// Stale returns the expired response if it's still inside the stale-if-error window,
+// it should be served only when the original handler fails to renew it.
+func (e *Entry) Stale() (*Response, bool) {
+	if e.response == nil || e.mustRevalidate || e.staleIfError <= 0 || e.valid() ||
+		time.Now().After(e.expiresAt.Add(e.staleIfError)) {
+		return nil, false
+	}
+	return e.response, true
+}
+
+// ExpiresAt returns the time which the current response expires.
+func (e *Entry) ExpiresAt() time.Time {
+	return e.expiresAt
+}
+
+// Touch extends the expiration of the current response to its life duration from now,
+// the life duration is the one which the response was stored with, i.e its "max-age".
+func (e *Entry) Touch() {
+	e.expiresAt = time.Now().Add(e.life)
+}
+
+// Extend extends the expiration of the current response to "d" from now,
+// unless it already expires later than that.
+func (e *Entry) Extend(d time.Duration) {
+	if expiresAt := time.Now().Add(d); expiresAt.After(e.expiresAt) {
+		e.expiresAt = expiresAt
+	}
+}
+
+// Copy returns a copy of the entry which shares its response,
+// i.e to touch or to extend a stored entry without modifying it,
+// the copy should be saved back to the store.
+func (e *Entry) Copy() *Entry {
+	c := *e
+	return &c
+}
+
+// Size returns the body's size, in bytes, of the current response, expired or not.
+func (e *Entry) Size() int {
+	if e.response == nil {
+		return 0
+	}
+	return len(e.response.Body())
+}
+
+// LifeTime returns the life duration of the entry's responses.
+func (e *Entry) LifeTime() time.Duration {
+	return e.life
+}
+
 // valid returns true if this entry's response is still valid
 // or false if the expiration time passed
 func (e *Entry) valid() bool {
 	return !time.Now().After(e.expiresAt)
 }
 
+// ExpirationFunc is the function which returns the lifetime of a response
+// based on its contents, i.e a "valid until" field of a JSON body,
+// a zero lifetime means that the response should not be cached.
+type ExpirationFunc func(statusCode int, contentType string, body []byte) time.Duration
+
 // LifeChanger is the function which returns
 // a duration which will be compared with the current
 // entry's (cache life)  duration
@@ -66,14 +143,14 @@ type LifeChanger func() time.Duration
 //
 // useful when we find a max-age header from the handler
 func (e *Entry) ChangeLifetime(fdur LifeChanger) {
-	if e.life < cfg.MinimumCacheDuration {
+	if e.life <= 0 || e.life < e.minimum {
 		newLifetime := fdur()
 		if newLifetime > e.life {
 			e.life = newLifetime
 		} else {
-			// if even the new lifetime is less than MinimumCacheDuration
+			// if even the new lifetime is less than the minimum
 			// then change set it explicitly here
-			e.life = cfg.MinimumCacheDuration
+			e.life = e.minimum
 		}
 	}
 }
@@ -84,6 +161,29 @@ func (e *Entry) ChangeLifetime(fdur LifeChanger) {
 func (e *Entry) Reset(statusCode int, contentType string,
 	body []byte, lifeChanger LifeChanger) {
 
+	e.setResponse(statusCode, contentType, body)
+	// check if a given life changer provided
+	// and if it does then execute the change life time
+	if lifeChanger != nil {
+		e.ChangeLifetime(lifeChanger)
+	}
+	e.expiresAt = time.Now().Add(e.life)
+}
+
+// ResetLifetime same as Reset but the new response
+// expires after the given "life" instead of the entry's life duration,
+// the "life" becomes the entry's life duration, so a Touch honors it too.
+//
+// useful when a specific response needs a different lifetime, i.e the 404 ones.
+func (e *Entry) ResetLifetime(statusCode int, contentType string,
+	body []byte, life time.Duration) {
+
+	e.setResponse(statusCode, contentType, body)
+	e.life = life
+	e.expiresAt = time.Now().Add(life)
+}
+
+func (e *Entry) setResponse(statusCode int, contentType string, body []byte) {
 	if e.response == nil {
 		e.response = &Response{}
 	}
@@ -96,10 +196,150 @@ func (e *Entry) Reset(statusCode int, contentType string,
 	}
 
 	e.response.body = body
-	// check if a given life changer provided
-	// and if it does then execute the change life time
-	if lifeChanger != nil {
-		e.ChangeLifetime(lifeChanger)
+	e.staleIfError = 0
+	e.mustRevalidate = false
+	e.response.revalidate = false
+	e.response.etag = ""
+	e.response.lastModified = ""
+	e.response.header = nil
+	e.response.contentEncoding = ""
+}
+
+// Revalidate marks the current response as one which must be revalidated
+// by the original handler before each reuse,
+// "etag" and "lastModified" are the response's validators, if any.
+//
+// It's called after Reset, until the next Reset.
+func (e *Entry) Revalidate(etag, lastModified string) {
+	if e.response == nil {
+		return
 	}
-	e.expiresAt = time.Now().Add(e.life)
+
+	e.response.revalidate = true
+	e.response.etag = etag
+	e.response.lastModified = lastModified
+}
+
+// SetHeader sets the headers of the current response which should be replayed,
+// i.e the "Set-Cookie" ones, see Response.Header.
+//
+// It's called after Reset, until the next Reset.
+func (e *Entry) SetHeader(header map[string][]string) {
+	if e.response == nil {
+		return
+	}
+
+	e.response.header = header
+}
+
+// SetContentEncoding sets the "Content-Encoding" header's value of the current response,
+// i.e "gzip", see Response.ContentEncoding.
+//
+// It's called after Reset, until the next Reset.
+func (e *Entry) SetContentEncoding(encoding string) {
+	if e.response == nil {
+		return
+	}
+
+	e.response.contentEncoding = encoding
+}
+
+// StaleIfError sets the duration after the expiration
+// which the current response can still be served if the original handler fails,
+// see Stale.
+//
+// It's called after Reset, until the next Reset.
+func (e *Entry) StaleIfError(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	e.staleIfError = d
+}
+
+// MustRevalidate marks the current response as one which must not be served stale
+// after its expiration, whatever its stale-if-error window is,
+// i.e a response with a "must-revalidate" directive, see Stale.
+//
+// It's called after Reset, until the next Reset.
+func (e *Entry) MustRevalidate() {
+	e.mustRevalidate = true
+}
+
+// entrySnapshot is the serializable form of an Entry.
+type entrySnapshot struct {
+	Life            time.Duration       `json:"life"`
+	Minimum         time.Duration       `json:"minimum"`
+	ExpiresAt       time.Time           `json:"expiresAt"`
+	StaleIfError    time.Duration       `json:"staleIfError,omitempty"`
+	MustRevalidate  bool                `json:"mustRevalidate,omitempty"`
+	StatusCode      int                 `json:"statusCode"`
+	ContentType     string              `json:"contentType"`
+	Body            []byte              `json:"body"`
+	Revalidate      bool                `json:"revalidate,omitempty"`
+	ETag            string              `json:"etag,omitempty"`
+	LastModified    string              `json:"lastModified,omitempty"`
+	Header          map[string][]string `json:"header,omitempty"`
+	ContentEncoding string              `json:"contentEncoding,omitempty"`
+}
+
+// snapshot returns the serializable form of the entry.
+func (e *Entry) snapshot() entrySnapshot {
+	s := entrySnapshot{
+		Life:           e.life,
+		Minimum:        e.minimum,
+		ExpiresAt:      e.expiresAt,
+		StaleIfError:   e.staleIfError,
+		MustRevalidate: e.mustRevalidate,
+	}
+	if res := e.response; res != nil {
+		s.StatusCode = res.statusCode
+		s.ContentType = res.contentType
+		s.Body = res.body
+		s.Revalidate = res.revalidate
+		s.ETag = res.etag
+		s.LastModified = res.lastModified
+		s.Header = res.header
+		s.ContentEncoding = res.contentEncoding
+	}
+	return s
+}
+
+// restore sets the entry's fields from its serializable form.
+func (e *Entry) restore(s entrySnapshot) {
+	e.life = s.Life
+	e.minimum = s.Minimum
+	e.expiresAt = s.ExpiresAt
+	e.staleIfError = s.StaleIfError
+	e.mustRevalidate = s.MustRevalidate
+	e.response = &Response{
+		statusCode:      s.StatusCode,
+		contentType:     s.ContentType,
+		body:            s.Body,
+		revalidate:      s.Revalidate,
+		etag:            s.ETag,
+		lastModified:    s.LastModified,
+		header:          s.Header,
+		contentEncoding: s.ContentEncoding,
+	}
+}
+
+// MarshalBinary encodes the entry and its response,
+// it's used by the stores which keep the entries outside of the memory.
+func (e *Entry) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e.snapshot()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes an entry which has been encoded by MarshalBinary.
+func (e *Entry) UnmarshalBinary(data []byte) error {
+	var s entrySnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+
+	e.restore(s)
+	return nil
 }
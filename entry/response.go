@@ -1,5 +1,10 @@
 package entry
 
+import (
+	"net/http"
+	"strings"
+)
+
 // Response is the cached response will be send to the clients
 // its fields setted at runtime on each of the non-cached executions
 // non-cached executions = first execution, and each time after
@@ -11,6 +16,18 @@ type Response struct {
 	contentType string
 	// body is the contents will be served by the cache handler
 	body []byte
+
+	// revalidate is true when the response must be revalidated
+	// by the original handler before each reuse.
+	revalidate bool
+	// etag and lastModified are the validators of a response which must be revalidated.
+	etag, lastModified string
+	// header keeps the response headers which should be replayed, if any,
+	// each value of a repeated header, i.e the "Set-Cookie", is kept separately.
+	header map[string][]string
+	// contentEncoding is the "Content-Encoding" header's value of the response, if any,
+	// the body is kept encoded.
+	contentEncoding string
 }
 
 // StatusCode returns a valid status code
@@ -33,3 +50,118 @@ func (r *Response) ContentType() string {
 func (r *Response) Body() []byte {
 	return r.body
 }
+
+// Revalidate returns true if the response must be revalidated
+// by the original handler before reused.
+func (r *Response) Revalidate() bool {
+	return r.revalidate
+}
+
+// ETag returns the "ETag" header's value of the response, if any.
+func (r *Response) ETag() string {
+	return r.etag
+}
+
+// LastModified returns the "Last-Modified" header's value of the response, if any.
+func (r *Response) LastModified() string {
+	return r.lastModified
+}
+
+// Header returns the stored headers of the response, if any,
+// each one of their values should be sent as a separate header.
+func (r *Response) Header() map[string][]string {
+	return r.header
+}
+
+// ContentEncoding returns the "Content-Encoding" header's value of the response, if any,
+// i.e "gzip", then its body is the encoded one.
+func (r *Response) ContentEncoding() string {
+	return r.contentEncoding
+}
+
+// NotModified returns true if the request's conditional headers,
+// "If-None-Match" and "If-Modified-Since", are matching this response's validators,
+// then a 304 status code can be sent instead of the response.
+func (r *Response) NotModified(ifNoneMatch, ifModifiedSince string) bool {
+	if ifNoneMatch != "" {
+		// If-None-Match has priority over the If-Modified-Since.
+		if strings.TrimSpace(ifNoneMatch) == "*" {
+			// matches any stored response.
+			return true
+		}
+
+		if r.etag == "" {
+			return false
+		}
+
+		// the If-None-Match uses the weak comparison, see RFC 7232 section 2.3.2.
+		opaque := opaqueTag(r.etag)
+		for _, etag := range splitETags(ifNoneMatch) {
+			if etag == "*" || opaqueTag(etag) == opaque {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince == "" || r.lastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(r.lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// opaqueTag returns the "etag" without its weakness indicator ("W/") and its quotes,
+// two etags are matching weakly if their opaque tags are the same.
+func opaqueTag(etag string) string {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		etag = etag[1 : len(etag)-1]
+	}
+	return etag
+}
+
+// splitETags splits a comma-separated list of etags, i.e an "If-None-Match" header's value,
+// leniently, a quoted etag can contain commas and an unquoted one ends at the next comma.
+func splitETags(list string) []string {
+	var etags []string
+	for i := 0; i < len(list); {
+		// skip the separators.
+		if c := list[i]; c == ',' || c == ' ' || c == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		if strings.HasPrefix(list[i:], "W/") {
+			i += 2
+		}
+
+		if i < len(list) && list[i] == '"' {
+			if end := strings.IndexByte(list[i+1:], '"'); end != -1 {
+				i += end + 2
+				etags = append(etags, list[start:i])
+				continue
+			}
+		}
+
+		// unquoted or unterminated quoted etag.
+		if end := strings.IndexByte(list[i:], ','); end != -1 {
+			i += end
+		} else {
+			i = len(list)
+		}
+		etags = append(etags, strings.TrimSpace(list[start:i]))
+	}
+	return etags
+}
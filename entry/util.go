@@ -1,24 +1,264 @@
 package entry
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
-var maxAgeExp = regexp.MustCompile(`maxage=(\d+)`)
+// maxAgeExp matches the "s-maxage", "max-age" and the legacy "maxage" directives
+// of a comma and/or whitespace separated "cache-control" header.
+var maxAgeExp = regexp.MustCompile(`(?i)(?:^|[,\s])(s-maxage|max-age|maxage)\s*=\s*"?(\d+)"?`)
+
+// staleIfErrorExp matches the "stale-if-error" directive
+// of a comma and/or whitespace separated "cache-control" header.
+var staleIfErrorExp = regexp.MustCompile(`(?i)(?:^|[,\s])stale-if-error\s*=\s*"?(\d+)"?`)
+
+// mustRevalidateExp matches the "must-revalidate" and the "proxy-revalidate" directives
+// of a comma and/or whitespace separated "cache-control" header.
+var mustRevalidateExp = regexp.MustCompile(`(?i)(?:^|[,\s])(?:must|proxy)-revalidate(?:$|[,\s])`)
+
+// HopByHopHeaders are the canonical names of the hop-by-hop headers of RFC 7230,
+// they are meaningful only for a single transport-level connection,
+// they are never stored nor replayed, see HopByHop.
+var HopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// HopByHop reports whether the header of the canonical "name" is a hop-by-hop one,
+// it's one of the HopByHopHeaders or it's listed by the "connection" header's value,
+// i.e "close, X-Trace" lists the "X-Trace" header.
+func HopByHop(name string, connection string) bool {
+	if HopByHopHeaders[name] {
+		return true
+	}
+
+	for _, listed := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(listed), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// uncacheableHeaders are the response headers which are never stored as they are,
+// they are kept by the entry's response itself, managed by the cache or set by the server on each response.
+var uncacheableHeaders = map[string]bool{
+	"Content-Type":     true,
+	"Content-Length":   true,
+	"Content-Encoding": true,
+	"Set-Cookie":       true,
+	"Date":             true,
+	"Server":           true,
+}
+
+// CacheableHeader reports whether the response header of the canonical "name"
+// can be stored and replayed on the cache hits, see the handlers' CacheHeaders.
+// The hop-by-hop headers are not, see HopByHop, the "connection" is the response's "Connection" header.
+func CacheableHeader(name string, connection string) bool {
+	return !uncacheableHeaders[name] && !HopByHop(name, connection)
+}
+
+// CORSHeader reports whether the response header of the canonical "name" is a CORS one,
+// i.e the "Access-Control-Allow-Origin", or the "Vary", they are stored and replayed
+// with the cached preflight responses, see the handlers' CachePreflight.
+func CORSHeader(name string) bool {
+	return strings.HasPrefix(name, "Access-Control-") || name == "Vary"
+}
+
+// PreflightKey returns the cache key's suffix of a CORS preflight request by its "Origin",
+// "Access-Control-Request-Method" and "Access-Control-Request-Headers" headers,
+// the requested headers are compared lowercased and sorted, so their order doesn't matter.
+func PreflightKey(origin, method, headers string) string {
+	var names []string
+	for _, name := range strings.Split(headers, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return "#origin=" + origin + "#method=" + strings.ToUpper(method) + "#headers=" + strings.Join(names, ",")
+}
+
+// ParseMustRevalidate reports whether the "cache-control" header has
+// a "must-revalidate" or a "proxy-revalidate" directive,
+// then an expired response must not be served stale.
+func ParseMustRevalidate(header string) bool {
+	return mustRevalidateExp.MatchString(header)
+}
+
+// FormatMaxAge returns a "cache-control" header's value
+// which lets the browsers, and the other shared caches, to cache a response for its "remaining" lifetime,
+// i.e "public, max-age=60".
+func FormatMaxAge(remaining time.Duration) string {
+	seconds := int64(remaining / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	return "public, max-age=" + strconv.FormatInt(seconds, 10)
+}
+
+// FormatRetryAfter returns a "retry-after" header's value of the "d" delay in seconds,
+// rounded up and at least one, i.e "2" of 1.5 seconds.
+func FormatRetryAfter(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// ParseStaleIfError parses the "stale-if-error" directive from the "cache-control" header,
+// returns seconds as int64
+// if directive not found or parse failed then it returns -1
+func ParseStaleIfError(header string) int64 {
+	m := staleIfErrorExp.FindStringSubmatch(header)
+	if m == nil {
+		return -1
+	}
+
+	v, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// ParseTTL parses the seconds of a time-to-live "header", i.e "X-Cache-TTL: 120",
+// returns false if it's empty, invalid or not positive.
+func ParseTTL(header string) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(header), 10, 64)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// ParseExpires parses the "expires" header, an absolute date, i.e "Wed, 21 Oct 2026 07:28:00 GMT",
+// returns the duration from now until that date, it's not positive for a past date.
+// If the header is empty or it's not a valid date then it returns false.
+func ParseExpires(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	expires, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	return time.Until(expires), true
+}
 
 // ParseMaxAge parses the max age from the receiver parameter, "cache-control" header
 // returns seconds as int64
+// the "s-maxage" has priority over the "max-age" as RFC 7234 says for shared caches.
 // if header not found or parse failed then it returns -1
 func ParseMaxAge(header string) int64 {
 	if header == "" {
 		return -1
 	}
-	m := maxAgeExp.FindStringSubmatch(header)
-	if len(m) == 2 {
-		if v, err := strconv.Atoi(m[1]); err == nil {
-			return int64(v)
+
+	maxAge := int64(-1)
+	for _, m := range maxAgeExp.FindAllStringSubmatch(header, -1) {
+		v, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if strings.EqualFold(m[1], "s-maxage") {
+			// s-maxage found, it's the shared cache value.
+			return v
+		}
+
+		if maxAge == -1 {
+			maxAge = v
+		}
+	}
+	return maxAge
+}
+
+// AcceptsEncoding reports whether the "acceptEncoding" request header,
+// i.e "gzip, deflate", accepts the "encoding", i.e "gzip".
+// An encoding with a zero quality value, i.e "gzip;q=0", is not accepted,
+// the explicit "encoding" overrides the "*" one, i.e "*, gzip;q=0" doesn't accept "gzip".
+func AcceptsEncoding(acceptEncoding string, encoding string) bool {
+	wildcard := false
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		name, params := v, ""
+		if i := strings.IndexByte(v, ';'); i >= 0 {
+			name, params = v[:i], v[i+1:]
+		}
+		name = strings.TrimSpace(name)
+		explicit := strings.EqualFold(name, encoding)
+		if !explicit && name != "*" {
+			continue
+		}
+
+		accepted := true
+		params = strings.Replace(strings.TrimSpace(params), " ", "", -1)
+		if q := strings.TrimPrefix(params, "q="); q != params {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				accepted = false
+			}
 		}
+		if explicit {
+			return accepted
+		}
+		// keep looking for the explicit one.
+		wildcard = accepted
+	}
+	return wildcard
+}
+
+// PreferredMediaType returns the preferred media type of the "accept" request header,
+// the one with the highest quality value, or the first one of the equal ones,
+// lowercased and without its parameters, i.e "application/json" of "text/xml;q=0.9, Application/JSON".
+// Returns an empty string if the header is empty or it doesn't accept anything.
+func PreferredMediaType(accept string) string {
+	preferred, preferredQ := "", 0.0
+	for _, v := range strings.Split(accept, ",") {
+		params := strings.Split(v, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+			if v := strings.TrimPrefix(param, "q="); v != param {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		if q > preferredQ {
+			preferred, preferredQ = mediaType, q
+		}
+	}
+	return preferred
+}
+
+// Gunzip returns the decompressed "body" of a "gzip" encoded response.
+func Gunzip(body []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
-	return -1
+	defer r.Close()
+	return ioutil.ReadAll(r)
 }
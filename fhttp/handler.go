@@ -1,14 +1,37 @@
 package fhttp
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/geekypanda/httpcache/cfg"
 	"github.com/geekypanda/httpcache/entry"
 	"github.com/geekypanda/httpcache/fhttp/rule"
+	"github.com/geekypanda/httpcache/ruleset"
+	"github.com/geekypanda/httpcache/server"
+	"github.com/geekypanda/httpcache/uri"
 	"github.com/valyala/fasthttp"
-	"time"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Handler the fasthttp cache service handler
+// Handler the fasthttp cache service handler,
+// it keeps one memory cache entry per request path and query.
 type Handler struct {
+	// disabled is 1 when the caching is disabled, see SetEnabled.
+	// It's accessed atomically.
+	disabled uint32
+	// gcStarted is 1 when the expired entries scan of the default memory store has been started,
+	// on its first stored response, or when it has been set by the GCInterval or the Close.
+	// It's accessed atomically.
+	gcStarted uint32
 
 	// bodyHandler the original route's handler
 	bodyHandler fasthttp.RequestHandler
@@ -17,20 +40,177 @@ type Handler struct {
 	//
 	// See more at rule.go
 	rule rule.Rule
+	// defaultRule and addedRules compose the rule,
+	// the defaultRule is executed first, see WithoutDefaultDeniers.
+	defaultRule rule.Rule
+	addedRules  []rule.Rule
+
+	// life is the expiration duration of each of the cache entries.
+	life time.Duration
+	// minimumLife is the minimum expiration duration of each of the cache entries,
+	// see MinimumLifetime.
+	minimumLife time.Duration
+
+	// entries keeps the cache entries, one per cache key,
+	// the cache key is the request's method, its path and its (filtered) query.
+	// Defaults to a memory store, see Store.
+	entries server.Store
+	// defaultEntries is true while the entries is the handler's own memory store,
+	// its expired entries scan is started by its first stored response
+	// and it's stopped when it's replaced, see Store and Close.
+	defaultEntries bool
+
+	// queryParams and ignoredQueryParams are the query parameters
+	// which participate or not in the cache key, see CacheQueryParams and IgnoreQueryParams.
+	queryParams, ignoredQueryParams []string
+
+	// keyNormalizer is optional, if not nil then it normalizes the cache keys,
+	// see KeyNormalizer.
+	keyNormalizer func(key string) string
+
+	// keyFunc is optional, if not nil then it returns the cache keys
+	// and whether the requests should be cached, see KeyFunc.
+	keyFunc func(*fasthttp.RequestCtx) (string, bool)
+
+	// keyByHost reports whether the request's host participates in the cache key,
+	// see KeyByHost.
+	keyByHost bool
+
+	// keyPrefixHeader is the request header which prefixes the cache key,
+	// empty means disabled, see KeyPrefixHeader.
+	keyPrefixHeader string
+	// keyPrefixFallback is the cache key's prefix of the requests without the keyPrefixHeader.
+	keyPrefixFallback string
+
+	// idempotencyHeader is the request header which keys the cached responses,
+	// empty means disabled, see IdempotencyKey.
+	idempotencyHeader string
+
+	// keyCookies are the request cookies which participate in the cache key,
+	// see KeyByCookies.
+	keyCookies []string
+
+	// varyAccept reports whether the preferred media type of the request's "Accept" header
+	// participates in the cache key, see VaryAccept.
+	varyAccept bool
+
+	// statusKey is optional, if not nil then it returns the expected status code of a request's response
+	// which participates in the cache key, see KeyByStatus.
+	statusKey func(*fasthttp.RequestCtx) int
+
+	// cachePreflight reports whether the CORS preflight responses are cached,
+	// see CachePreflight.
+	cachePreflight bool
+
+	// cacheCookies reports whether the "Set-Cookie" headers are stored and replayed,
+	// see CacheCookies.
+	cacheCookies bool
+	// allowSetCookie reports whether the responses which set cookies are cached,
+	// see AllowSetCookie.
+	allowSetCookie bool
+	// cacheHeaders reports whether the rest of the response headers are stored and replayed,
+	// see CacheHeaders.
+	cacheHeaders bool
+
+	// expiration is optional, if not nil then it returns the lifetimes of the responses,
+	// see Expiration.
+	expiration entry.ExpirationFunc
+
+	// storeTransform and serveTransform are optional, if not nil then they transform
+	// the bodies before they're stored and before they're served from the cache,
+	// see TransformStored and TransformServed.
+	storeTransform, serveTransform func([]byte) []byte
+
+	// ttlPatterns are the lifetimes by request path pattern, see TTLByPattern.
+	ttlPatterns uri.TTLPatterns
+
+	// notFoundLife is the lifetime of the cached 404 responses,
+	// a negative value means that the entry's life duration is used.
+	//
+	// See NotFoundTTL.
+	notFoundLife time.Duration
+
+	// cacheStatusHeader is the response header key which tells
+	// if the response was served from the cache or not, empty means disabled.
+	//
+	// See CacheStatusHeader.
+	cacheStatusHeader string
+
+	// directives maps the response's "Cache-Control" directives to a behavior,
+	// defaults to the ruleset.DefaultDirectives.
+	//
+	// See Directive.
+	directives map[string]ruleset.Behavior
+
+	// tracer is optional, if not nil then the original handler's executions
+	// are traced, see Tracer.
+	tracer trace.Tracer
+
+	// logger reports the cache hits, misses and stores, defaults to the cfg.NopLogger,
+	// see Logger.
+	logger cfg.Logger
+
+	// maxBodySize is the maximum body's size, in bytes, of a cached response,
+	// zero means no limit, see MaxBodySize.
+	maxBodySize int
+
+	// staleIfError is the default stale-if-error window of the cache entries,
+	// see StaleIfError.
+	staleIfError time.Duration
+
+	// slidingExpiration reports whether the cache hits extend the expiration of their entries,
+	// see SlidingExpiration.
+	slidingExpiration bool
+
+	// hitCacheControl is optional, if not nil then it returns the "Cache-Control" header
+	// of the cache hits, see HitCacheControl.
+	hitCacheControl func(remaining time.Duration) string
+
+	// ttlHeader is the response header which sets the lifetime of the cached responses,
+	// empty means disabled, see TTLHeader.
+	ttlHeader string
 
-	// entry is the memory cache entry
-	entry *entry.Entry
+	// refreshQueryParam is the query parameter which forces a refresh of the cached responses
+	// by the trusted requests, empty means disabled, see RefreshQueryParam.
+	refreshQueryParam string
+	refreshTrusted    func(*fasthttp.RequestCtx) bool
+
+	// slowerThan is the minimum duration of the original handler's execution
+	// of a response to be cached, see CacheIfSlowerThan.
+	slowerThan time.Duration
+
+	// refreshing counts the in-flight executions of the original handler per cache key,
+	// see Refreshing.
+	refreshing   map[string]int
+	refreshingMu sync.Mutex
+
+	// origins limits the concurrent executions of the original handler, nil means no limit,
+	// originTimeout is the maximum wait for a free slot, see MaxConcurrentOriginCalls.
+	origins       chan struct{}
+	originTimeout time.Duration
+	// overloadRetryAfter is the "Retry-After" of the rejected excess requests,
+	// zero means that they wait for a free slot, see ShedOverload.
+	overloadRetryAfter time.Duration
+
+	// panicHandler handles the original handler's panics, see OnPanic.
+	panicHandler PanicHandler
 }
 
 // NewHandler returns a new cached handler
 func NewHandler(bodyHandler fasthttp.RequestHandler,
 	expireDuration time.Duration) *Handler {
-	e := entry.NewEntry(expireDuration)
-
 	return &Handler{
-		bodyHandler: bodyHandler,
-		rule:        DefaultRuleSet,
-		entry:       e,
+		bodyHandler:    bodyHandler,
+		rule:           DefaultRuleSet,
+		defaultRule:    DefaultRuleSet,
+		life:           expireDuration,
+		minimumLife:    cfg.MinimumCacheDuration,
+		entries:        server.NewMemoryStore(),
+		defaultEntries: true,
+		notFoundLife:   -1,
+		directives:     ruleset.DefaultDirectives,
+		panicHandler:   DefaultPanicHandler,
+		logger:         cfg.NopLogger,
 	}
 }
 
@@ -44,6 +224,8 @@ func (h *Handler) Rule(r rule.Rule) *Handler {
 		r = rule.Satisfied()
 	}
 	h.rule = r
+	h.defaultRule = r
+	h.addedRules = nil
 
 	return h
 }
@@ -56,50 +238,1421 @@ func (h *Handler) AddRule(r rule.Rule) *Handler {
 		return h
 	}
 
-	h.rule = rule.Chained(h.rule, r)
+	h.addedRules = append(h.addedRules, r)
+	h.rule = rule.Chained(h.defaultRule, h.addedRules...)
 	return h
 }
 
-func (h *Handler) ServeHTTP(reqCtx *fasthttp.RequestCtx) {
+// WithDeniers adds deniers in the chain, the requests that at least one of them returns true for
+// are served by the original handler as they are, without the cache,
+// i.e the requests of a specific method or with a specific header.
+//
+// returns itself.
+func (h *Handler) WithDeniers(deniers ...func(*fasthttp.RequestCtx) bool) *Handler {
+	if len(deniers) == 0 {
+		return h
+	}
 
-	// check for pre-cache validators, if at least one of them return false
-	// for this specific request, then skip the whole cache
-	if !h.rule.Claim(reqCtx) {
-		h.bodyHandler(reqCtx)
-		return
+	return h.AddRule(rule.Deny(deniers...))
+}
+
+// WithoutDefaultDeniers removes the DefaultRuleSet, or the one which is set by Rule, from this handler's chain,
+// the rules added by AddRule and WithDeniers are kept.
+// The DefaultRuleSet package variable is not modified, the rest of the handlers are not affected.
+//
+// returns itself.
+func (h *Handler) WithoutDefaultDeniers() *Handler {
+	h.defaultRule = rule.Satisfied()
+	h.rule = rule.Chained(h.defaultRule, h.addedRules...)
+	return h
+}
+
+// NotFoundTTL sets the lifetime of the 404 responses,
+// it's useful when you want to cache the "not found" responses
+// for a shorter duration than the successful ones.
+// A zero duration means that 404 responses are not cached at all.
+//
+// returns itself.
+func (h *Handler) NotFoundTTL(d time.Duration) *Handler {
+	if d < 0 {
+		d = 0
 	}
+	h.notFoundLife = d
+	return h
+}
 
-	// check if we have a stored response( it is not expired)
-	res, exists := h.entry.Response()
-	if !exists {
-		// if it's not valid then execute the original handler
-		h.bodyHandler(reqCtx)
+// MaxBodySize sets the maximum body's size, in bytes, of a response which can be cached,
+// bigger responses are still sent to the client but they are not cached.
+// Zero means no limit, the default.
+//
+// returns itself.
+func (h *Handler) MaxBodySize(n int) *Handler {
+	if n < 0 {
+		n = 0
+	}
+	h.maxBodySize = n
+	return h
+}
 
-		// check if it's a valid response, if it's not then just return.
-		if !h.rule.Valid(reqCtx) {
-			return
-		}
+// Tracer sets an OpenTelemetry tracer which traces the original handler executions on cache misses,
+// each span is annotated with the cache key, the cache hit and if the response was stored.
+// Defaults to nil, no tracing.
+//
+// returns itself.
+func (h *Handler) Tracer(tracer trace.Tracer) *Handler {
+	h.tracer = tracer
+	return h
+}
 
-		// no need to copy the body, its already done inside
-		body := reqCtx.Response.Body()
-		if len(body) == 0 {
-			// if no body then just exit
-			return
+// Logger sets the logger which reports the cache hits, misses and stores,
+// i.e log.New(os.Stderr, "", log.LstdFlags).
+// If "logger" is nil then the cfg.NopLogger is used instead, the default.
+//
+// returns itself.
+func (h *Handler) Logger(logger cfg.Logger) *Handler {
+	if logger == nil {
+		logger = cfg.NopLogger
+	}
+	h.logger = logger
+	return h
+}
+
+// MinimumLifetime sets the minimum expiration duration of the cache entries,
+// an expiration duration lower than that is raised to the minimum.
+// Defaults to the cfg.MinimumCacheDuration, a zero minimum means that the
+// handler's expiration duration is honored as it's, i.e one second or less.
+//
+// It should be called before the handler starts serving.
+//
+// returns itself.
+func (h *Handler) MinimumLifetime(d time.Duration) *Handler {
+	if d < 0 {
+		d = 0
+	}
+	h.minimumLife = d
+	return h
+}
+
+// CacheIfSlowerThan caches only the responses which took the original handler longer than "d" to generate,
+// the faster ones are passed through uncached, this way the memory is spent only to the expensive responses.
+// The executions of the cache misses and of the stale responses' refreshes are timed, a "d" <=0 disables it, the default.
+//
+// returns itself.
+func (h *Handler) CacheIfSlowerThan(d time.Duration) *Handler {
+	h.slowerThan = d
+	return h
+}
+
+// HitCacheControl sets the "Cache-Control" header of the cache hits to the result of the "fn"
+// with the remaining lifetime of their entries, and their "Expires" header to the entries' expiration,
+// this way the browsers cache the responses as long as this handler does.
+// If "fn" is nil then the entry.FormatMaxAge is used instead, i.e "public, max-age=60".
+// The responses which should be revalidated on each reuse are not affected.
+//
+// returns itself.
+func (h *Handler) HitCacheControl(fn func(remaining time.Duration) string) *Handler {
+	if fn == nil {
+		fn = entry.FormatMaxAge
+	}
+	h.hitCacheControl = fn
+	return h
+}
+
+// SlidingExpiration makes each cache hit to extend the expiration of its entry
+// to the entry's lifetime from now, instead of a fixed expiration since the response was stored,
+// useful for the session-like cached contents. The lifetime is the one which the response
+// was stored with, so a "max-age" directive is honored on each hit too.
+// Note that each hit saves its entry back to the store, it's disabled by default.
+//
+// returns itself.
+func (h *Handler) SlidingExpiration() *Handler {
+	h.slidingExpiration = true
+	return h
+}
+
+// StaleIfError sets the default duration after the expiration which a cached response
+// can still be served, with a "Warning" header, if the original handler fails to renew it,
+// with a 5xx status code or a panic.
+// The "stale-if-error" directive of a response's "Cache-Control" header has priority over it.
+// Defaults to zero, only the responses with a "stale-if-error" directive are served stale.
+//
+// returns itself.
+func (h *Handler) StaleIfError(d time.Duration) *Handler {
+	if d < 0 {
+		d = 0
+	}
+	h.staleIfError = d
+	return h
+}
+
+// MaxConcurrentOriginCalls limits the concurrent executions of the original handler,
+// of all the cache keys, to protect a fragile backend, a "n" <=0 means no limit.
+// The excess requests wait for a free slot up to the "timeout",
+// a "timeout" <=0 means that they wait as long as it takes, or until the request is canceled.
+// If there is no free slot in time then the stale response is served, if any,
+// otherwise the request is rejected with a 503 status code.
+//
+// It should be called before the handler starts serving.
+//
+// returns itself.
+func (h *Handler) MaxConcurrentOriginCalls(n int, timeout time.Duration) *Handler {
+	if n <= 0 {
+		h.origins = nil
+		return h
+	}
+	h.origins = make(chan struct{}, n)
+	h.originTimeout = timeout
+	return h
+}
+
+// ShedOverload rejects the excess requests of the MaxConcurrentOriginCalls right away, instead of their wait for a free slot,
+// with a 503 status code and a "Retry-After" header of the "retryAfter", in seconds,
+// this way a spike doesn't pile up the requests behind a saturated backend. The stale responses are still served, if any.
+// A "retryAfter" <=0 disables it, the default.
+//
+// returns itself.
+func (h *Handler) ShedOverload(retryAfter time.Duration) *Handler {
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+	h.overloadRetryAfter = retryAfter
+	return h
+}
+
+// acquireOrigin acquires a slot of the original handler's executions,
+// returns false if there is no free slot in time, see MaxConcurrentOriginCalls and ShedOverload.
+func (h *Handler) acquireOrigin(ctx context.Context) bool {
+	if h.origins == nil {
+		return true
+	}
+
+	select {
+	case h.origins <- struct{}{}:
+		return true
+	default:
+	}
+
+	if h.overloadRetryAfter > 0 {
+		// shed it, don't wait.
+		return false
+	}
+
+	var timeout <-chan time.Time
+	if h.originTimeout > 0 {
+		t := time.NewTimer(h.originTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case h.origins <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serveOverloaded rejects a request which didn't acquire a slot of the original handler's executions,
+// with a 503 status code and the "Retry-After" header of the ShedOverload, if any.
+func (h *Handler) serveOverloaded(reqCtx *fasthttp.RequestCtx) {
+	if h.overloadRetryAfter > 0 {
+		reqCtx.Response.Header.Set("Retry-After", entry.FormatRetryAfter(h.overloadRetryAfter))
+	}
+	reqCtx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+}
+
+// releaseOrigin releases the slot which has been acquired by the acquireOrigin.
+func (h *Handler) releaseOrigin() {
+	if h.origins != nil {
+		<-h.origins
+	}
+}
+
+// OnPanic sets the handler which is executed when the original handler panics,
+// the panicked response is not cached.
+// Defaults to the DefaultPanicHandler.
+//
+// returns itself.
+func (h *Handler) OnPanic(handler PanicHandler) *Handler {
+	if handler == nil {
+		handler = DefaultPanicHandler
+	}
+	h.panicHandler = handler
+	return h
+}
+
+// CacheQueryParams sets the only query parameters which participate in the cache key,
+// i.e CacheQueryParams("q", "page") caches the "/search?q=golang&page=2&utm_source=x"
+// and the "/search?q=golang&page=2" responses as one.
+// The rest of the parameters are still passed to the original handler.
+//
+// returns itself.
+func (h *Handler) CacheQueryParams(include ...string) *Handler {
+	h.queryParams = include
+	return h
+}
+
+// IgnoreQueryParams sets the query parameters which don't participate in the cache key,
+// i.e IgnoreQueryParams("utm_source", "utm_medium").
+// These parameters are still passed to the original handler.
+//
+// returns itself.
+func (h *Handler) IgnoreQueryParams(exclude ...string) *Handler {
+	h.ignoredQueryParams = exclude
+	if h.refreshQueryParam != "" {
+		h.ignoredQueryParams = append(exclude[:len(exclude):len(exclude)], h.refreshQueryParam)
+	}
+	return h
+}
+
+// RefreshQueryParam sets the query parameter which forces a refresh of a cached response,
+// i.e "/articles?__refresh=1" executes the original handler, overwrites the cached response of "/articles"
+// and serves the new one, useful to bust the cache of specific pages on demand.
+// It applies only to the requests which the "trusted" reports as trusted, i.e the internal callers,
+// a nil "trusted" trusts no request. The parameter's value should be a true one, see strconv.ParseBool.
+// The parameter doesn't participate in the cache key.
+//
+// returns itself.
+func (h *Handler) RefreshQueryParam(name string, trusted func(*fasthttp.RequestCtx) bool) *Handler {
+	h.refreshQueryParam = name
+	h.refreshTrusted = trusted
+	h.ignoredQueryParams = append(h.ignoredQueryParams[:len(h.ignoredQueryParams):len(h.ignoredQueryParams)], name)
+	return h
+}
+
+// refreshRequested reports whether the "reqCtx" request forces a refresh of its cached response,
+// see RefreshQueryParam.
+func (h *Handler) refreshRequested(reqCtx *fasthttp.RequestCtx) bool {
+	if h.refreshQueryParam == "" || h.refreshTrusted == nil {
+		return false
+	}
+	if refresh, _ := strconv.ParseBool(string(reqCtx.QueryArgs().Peek(h.refreshQueryParam))); !refresh {
+		return false
+	}
+	return h.refreshTrusted(reqCtx)
+}
+
+// KeyNormalizer sets a function which normalizes the cache key, the request's path and its query,
+// before the lookup and the store, this way the equivalent requests share the same cached response.
+// It's applied after the CacheQueryParams and IgnoreQueryParams filters.
+// See the uri.NormalizeKey builtin normalizer which lowercases the path and strips its trailing slashes.
+//
+// returns itself.
+func (h *Handler) KeyNormalizer(normalizer func(key string) string) *Handler {
+	h.keyNormalizer = normalizer
+	return h
+}
+
+// Expiration sets a function which returns the lifetime of each response based on its contents,
+// it's called after the original handler is executed, a zero lifetime means that the response is not cached.
+// It has priority over the rest of the lifetime options.
+//
+// returns itself.
+func (h *Handler) Expiration(fn entry.ExpirationFunc) *Handler {
+	h.expiration = fn
+	return h
+}
+
+// TransformStored sets a function which transforms the bodies of the responses before they're stored,
+// i.e minifies the HTML, the client of the original handler's execution receives the original body.
+//
+// returns itself.
+func (h *Handler) TransformStored(fn func(body []byte) []byte) *Handler {
+	h.storeTransform = fn
+	return h
+}
+
+// TransformServed sets a function which transforms the cached bodies before they're served,
+// i.e injects a timestamp comment, the function should not modify the given body, it's the cached one.
+//
+// returns itself.
+func (h *Handler) TransformServed(fn func(body []byte) []byte) *Handler {
+	h.serveTransform = fn
+	return h
+}
+
+// servedBody returns the body of the cached "res" response which should be served to the "reqCtx" request,
+// a "gzip" encoded body is decompressed for the clients which don't accept it,
+// the "Content-Encoding" and the "Vary" headers are set to the response accordingly.
+func (h *Handler) servedBody(reqCtx *fasthttp.RequestCtx, res *entry.Response) []byte {
+	body := res.Body()
+	if encoding := res.ContentEncoding(); encoding != "" {
+		reqCtx.Response.Header.Add("Vary", "Accept-Encoding")
+		if encoding == "gzip" && !entry.AcceptsEncoding(string(reqCtx.Request.Header.Peek("Accept-Encoding")), encoding) {
+			if decoded, err := entry.Gunzip(body); err == nil {
+				body, encoding = decoded, ""
+			}
+		}
+		if encoding != "" {
+			reqCtx.Response.Header.Set("Content-Encoding", encoding)
+		} else {
+			reqCtx.Response.Header.Del("Content-Encoding")
 		}
+	}
 
-		// and re-new the entry's response with the new data
-		statusCode := reqCtx.Response.StatusCode()
-		contentType := string(reqCtx.Response.Header.ContentType())
+	if h.serveTransform == nil {
+		return body
+	}
+	return h.serveTransform(body)
+}
 
-		// check for an expiration time if the
-		// given expiration was not valid &
-		// update the response & release the recorder
-		h.entry.Reset(statusCode, contentType, body, GetMaxAge(reqCtx))
-		return
+// TTLHeader sets the response header which the original handler can write to set the lifetime
+// of its cached response in seconds, i.e "X-Cache-TTL: 120", it has priority over the other lifetimes.
+// The header is removed from the response before it's served, it's never cached.
+// An absent or invalid value falls back to the configured expiration.
+//
+// returns itself.
+func (h *Handler) TTLHeader(name string) *Handler {
+	h.ttlHeader = name
+	return h
+}
+
+// responseTTL removes the TTL header from the response and returns its lifetime, see TTLHeader,
+// returns false if it's absent or invalid.
+func (h *Handler) responseTTL(reqCtx *fasthttp.RequestCtx) (time.Duration, bool) {
+	if h.ttlHeader == "" {
+		return 0, false
 	}
+	value := string(reqCtx.Response.Header.Peek(h.ttlHeader))
+	reqCtx.Response.Header.Del(h.ttlHeader)
+	return entry.ParseTTL(value)
+}
 
-	// if it's valid then just write the cached results
-	reqCtx.SetStatusCode(res.StatusCode())
-	reqCtx.SetContentType(res.ContentType())
-	reqCtx.SetBody(res.Body())
+// TTLByPattern sets the lifetimes of the responses by their request path pattern,
+// i.e {"/static/*": time.Hour, "/api/*": 30 * time.Second}, this way a handler (or a router) which serves many routes
+// declares its TTL policy in one place. The longest matching pattern wins, see uri.TTLPatterns.
+// The handler's expiration is used for the paths that no pattern matches.
+//
+// returns itself.
+func (h *Handler) TTLByPattern(patterns map[string]time.Duration) *Handler {
+	h.ttlPatterns = uri.TTLPatterns(patterns)
+	return h
+}
+
+// IdempotencyKey makes the "header" request header, i.e "Idempotency-Key", part of the cache key
+// and it caches only the requests which send it, whatever their method is,
+// this way the first response of an idempotent POST is replayed to its retries with the same idempotency key,
+// useful for the payment and webhook endpoints.
+// The requests without the header bypass the cache.
+//
+// Note that the default rules bypass the requests with an "Authorization" header, see WithoutDefaultDeniers.
+//
+// returns itself.
+func (h *Handler) IdempotencyKey(header string) *Handler {
+	h.idempotencyHeader = header
+	return h
+}
+
+// CacheHeaders stores the headers of the cached responses and replays them on the cache hits,
+// i.e the "Content-Language" or the "Access-Control-Allow-Origin", by default only the status code,
+// the content type and the body are stored.
+// The connection-level headers and the ones which are managed by the cache are not stored, see entry.CacheableHeader,
+// the "Set-Cookie" headers are stored only with the CacheCookies.
+//
+// returns itself.
+func (h *Handler) CacheHeaders() *Handler {
+	h.cacheHeaders = true
+	return h
+}
+
+// AllowSetCookie caches the responses which set cookies,
+// they are not cached by default, see SetCookieRule.
+// Their "Set-Cookie" headers are not stored, use the CacheCookies to replay them.
+//
+// returns itself.
+func (h *Handler) AllowSetCookie() *Handler {
+	h.allowSetCookie = true
+	return h
+}
+
+// CacheCookies stores the "Set-Cookie" headers of the cached responses
+// and replays each one of them on the cache hits, they are not replayed by default.
+// It implies the AllowSetCookie.
+//
+// Caching the responses with cookies is dangerous for a shared cache,
+// a user's cookie is sent to all the users of the cached response,
+// enable it only for the cookies which are the same for all of them.
+//
+// returns itself.
+func (h *Handler) CacheCookies() *Handler {
+	h.cacheCookies = true
+	h.allowSetCookie = true
+	return h
+}
+
+// GCInterval sets the interval of the store's expired entries scan, independently of the entries' lifetimes,
+// a too frequent scan wastes CPU, a too rare one wastes memory.
+// A value <=0 disables it. The default memory store is scanned every expiration duration of the handler,
+// or every minute if the lifetimes are taken by the headers, so the entries of the unique keys, i.e queries,
+// which are never requested again are removed too. Its scan is started by its first stored response,
+// so the handlers which never store anything don't run it, and it's stopped by the Close.
+// It does nothing if the store doesn't implement the server.GarbageCollector,
+// so call it after the Store.
+//
+// returns itself.
+func (h *Handler) GCInterval(d time.Duration) *Handler {
+	if h.defaultEntries {
+		// the explicit interval has priority over the lazy one, see startGC.
+		atomic.StoreUint32(&h.gcStarted, 1)
+	}
+	if gc, ok := h.entries.(server.GarbageCollector); ok {
+		gc.SetGCInterval(d)
+	}
+	return h
+}
+
+// KeyFunc sets a function which returns the cache key of a request and whether it should be cached at all,
+// i.e it keys the paginated responses by a canonical subset of their query, see IdempotencyKey too.
+// A false result bypasses the cache. The request's method is prepended to the key, the rules are still applied.
+// It replaces the query filters, the key normalizer and the KeyByHost, the Invalidate is not aware of its keys.
+//
+// returns itself.
+func (h *Handler) KeyFunc(fn func(*fasthttp.RequestCtx) (string, bool)) *Handler {
+	h.keyFunc = fn
+	return h
+}
+
+// KeyByHost makes the request's host part of the cache key, i.e "a.example.com/articles",
+// this way a virtual-hosted, multi-tenant, server doesn't share the cached responses of the same paths between its hosts.
+// The Invalidate's request uris should be prefixed by the host too.
+//
+// returns itself.
+func (h *Handler) KeyByHost() *Handler {
+	h.keyByHost = true
+	return h
+}
+
+// KeyPrefixHeader makes the value of the "header" request header the prefix of the cache key,
+// i.e the "X-Tenant-ID" of a multi-tenant server, this way each tenant keeps its own cached responses
+// and there is no leakage between them through the shared cache keys.
+// It's applied on top of the path and query keying, the KeyByHost and the KeyFunc.
+// The requests without that header are prefixed by the "fallback", if empty then
+// they share the default cache key, which is the one that Invalidate removes.
+// The Invalidate's request uris of a specific prefix should be written as "prefix|/articles".
+//
+// returns itself.
+func (h *Handler) KeyPrefixHeader(header, fallback string) *Handler {
+	h.keyPrefixHeader = header
+	h.keyPrefixFallback = fallback
+	return h
+}
+
+// keyPrefix returns the cache key's prefix of the "reqCtx" request, see KeyPrefixHeader.
+func (h *Handler) keyPrefix(reqCtx *fasthttp.RequestCtx) string {
+	if h.keyPrefixHeader == "" {
+		return ""
+	}
+	prefix := string(reqCtx.Request.Header.Peek(h.keyPrefixHeader))
+	if prefix == "" {
+		prefix = h.keyPrefixFallback
+	}
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "|"
+}
+
+// KeyByCookies makes the values of the "names" request cookies part of the cache key,
+// i.e the "bucket" cookie of an A/B testing, this way each cookie value keeps its own cached responses.
+// The requests without any of these cookies share the default cache key,
+// which is the one that Invalidate removes.
+//
+// returns itself.
+func (h *Handler) KeyByCookies(names ...string) *Handler {
+	h.keyCookies = names
+	return h
+}
+
+// VaryAccept makes the preferred media type of the request's "Accept" header part of the cache key,
+// i.e the "application/json" and the "text/xml" responses of a content negotiation are cached separately.
+// The equivalent "Accept" headers share their cache key, the media types are compared
+// lowercased and without their parameters and only the one with the highest quality value counts.
+// The requests without an "Accept" header share the default cache key,
+// which is the one that Invalidate removes.
+//
+// returns itself.
+func (h *Handler) VaryAccept() *Handler {
+	h.varyAccept = true
+	return h
+}
+
+// KeyByStatus makes the status code of the responses part of the cache key, i.e "/video#status=206",
+// this way a handler which responds to the same url with different cacheable statuses,
+// i.e 200 or 206 by content negotiation, keeps each one of them as a separate variant.
+// It implies a two-phase key: the request's key, suffixed by the status code which the "expected" returns,
+// i.e 206 for the requests with a "Range" header and 200 for the rest, is claimed and served,
+// and the key suffixed by the actual status code of the original handler's response is stored.
+// A nil "expected" disables it, the default. The Invalidate and the Refreshing are not aware of the variants.
+//
+// returns itself.
+func (h *Handler) KeyByStatus(expected func(*fasthttp.RequestCtx) int) *Handler {
+	h.statusKey = expected
+	return h
+}
+
+// CachePreflight caches the responses of the CORS preflight requests, the "OPTIONS" ones,
+// even without a body, i.e a 204, with their "Access-Control-*" and "Vary" headers which are replayed on the cache hits.
+// They are keyed by their "Origin", "Access-Control-Request-Method" and "Access-Control-Request-Headers" headers too,
+// this way each origin gets its own allowed methods and headers, see entry.PreflightKey.
+// Note that the rules should not deny the "OPTIONS" requests, i.e Rules().Method("GET", "OPTIONS").
+//
+// returns itself.
+func (h *Handler) CachePreflight() *Handler {
+	h.cachePreflight = true
+	return h
+}
+
+// preflight reports whether the "reqCtx" request is a CORS preflight one which is cached,
+// see CachePreflight.
+func (h *Handler) preflight(reqCtx *fasthttp.RequestCtx) bool {
+	return h.cachePreflight && reqCtx.IsOptions()
+}
+
+// host returns the request's host if it participates in the cache key, see KeyByHost.
+func (h *Handler) host(reqCtx *fasthttp.RequestCtx) string {
+	if !h.keyByHost {
+		return ""
+	}
+	return string(reqCtx.Host())
+}
+
+// cacheKey returns the cache key of the "reqCtx" request,
+// returns false if the request should bypass the cache, see KeyFunc and IdempotencyKey.
+func (h *Handler) cacheKey(reqCtx *fasthttp.RequestCtx) (string, bool) {
+	var key string
+	if h.keyFunc != nil {
+		k, ok := h.keyFunc(reqCtx)
+		if !ok {
+			return "", false
+		}
+		key = getCacheMethod(reqCtx) + h.keyPrefix(reqCtx) + k
+	} else {
+		key = getCacheMethod(reqCtx) + h.keyPrefix(reqCtx) + h.host(reqCtx) + h.normalize(getCacheKey(reqCtx, h.queryParams, h.ignoredQueryParams))
+	}
+
+	if h.idempotencyHeader != "" {
+		idempotencyKey := reqCtx.Request.Header.Peek(h.idempotencyHeader)
+		if len(idempotencyKey) == 0 {
+			return "", false
+		}
+		key += "#" + string(idempotencyKey)
+	}
+
+	for _, name := range h.keyCookies {
+		if value := reqCtx.Request.Header.Cookie(name); len(value) > 0 {
+			key += "#" + name + "=" + string(value)
+		}
+	}
+
+	if h.varyAccept {
+		if mediaType := entry.PreferredMediaType(string(reqCtx.Request.Header.Peek("Accept"))); mediaType != "" {
+			key += "#accept=" + mediaType
+		}
+	}
+
+	if h.preflight(reqCtx) {
+		key += entry.PreflightKey(string(reqCtx.Request.Header.Peek("Origin")),
+			string(reqCtx.Request.Header.Peek("Access-Control-Request-Method")),
+			string(reqCtx.Request.Header.Peek("Access-Control-Request-Headers")))
+	}
+
+	if h.statusKey != nil {
+		key = statusVariantKey(key, h.statusKey(reqCtx))
+	}
+	return key, true
+}
+
+// normalize returns the "key" normalized by the keyNormalizer, if any.
+func (h *Handler) normalize(key string) string {
+	if h.keyNormalizer == nil {
+		return key
+	}
+	return h.keyNormalizer(key)
+}
+
+// Invalidate removes the cached GET response of the "requestURI",
+// the request's path and its query, i.e "/articles?page=2".
+// The query filters and the key normalizer are applied to it as they do to the requests.
+func (h *Handler) Invalidate(requestURI string) {
+	h.entries.Remove(h.requestURIKey(requestURI))
+}
+
+// Extend extends the expiration of the cached GET response of the "requestURI", see Invalidate,
+// to "d" from now, unless it already expires later than that,
+// i.e when it's known that the underline data are still the same, without an execution of the original handler.
+// Returns false if there is no cached response.
+func (h *Handler) Extend(requestURI string, d time.Duration) bool {
+	return h.extend(h.requestURIKey(requestURI), d)
+}
+
+// ExtendRequest same as Extend but the cached response is the one of the "reqCtx" request.
+func (h *Handler) ExtendRequest(reqCtx *fasthttp.RequestCtx, d time.Duration) bool {
+	key, ok := h.cacheKey(reqCtx)
+	if !ok {
+		return false
+	}
+	return h.extend(key, d)
+}
+
+// extend extends the expiration of the entry of the "key", if exists.
+func (h *Handler) extend(key string, d time.Duration) bool {
+	var generation uint64
+	if g, ok := h.entries.(server.Generational); ok {
+		generation = g.Generation()
+	}
+
+	e := h.entries.Get(key)
+	if e == nil {
+		return false
+	}
+	if _, ok := e.Response(); !ok {
+		if _, ok = e.Stale(); !ok {
+			// it's gone.
+			return false
+		}
+	}
+
+	// the stored entry may be served meanwhile, extend a copy of it.
+	extended := e.Copy()
+	extended.Extend(d)
+	return h.swapEntry(key, generation, e, extended)
+}
+
+// WarmURLs populates the cache with the responses of the "paths", i.e "/", "/articles?page=1",
+// each one of them is requested with a GET request to the "base" url, i.e "http://example.com",
+// through this handler, so its rules are respected and the non-cacheable responses are skipped.
+// The requests run concurrently by up to "workers" workers, a value <=0 means one,
+// useful to warm the hot paths on a deploy.
+//
+// Returns the errors by path, the requests which could not be made and the server errors,
+// it's empty if all of them succeeded.
+func (h *Handler) WarmURLs(base string, paths []string, workers int) map[string]error {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		errs = make(map[string]error)
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+	jobs := make(chan string)
+	for i := 0; i < workers && i < len(paths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				if err := h.warm(base + path); err != nil {
+					mu.Lock()
+					errs[path] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// warm requests the "url" through this handler, see WarmURLs.
+func (h *Handler) warm(url string) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(url)
+	if len(req.URI().Host()) == 0 {
+		return fmt.Errorf("httpcache: warm %s: missing host", url)
+	}
+
+	var reqCtx fasthttp.RequestCtx
+	reqCtx.Init(req, nil, nil)
+	h.ServeHTTP(&reqCtx)
+	if statusCode := reqCtx.Response.StatusCode(); statusCode >= fasthttp.StatusInternalServerError {
+		return fmt.Errorf("httpcache: warm %s: status %d", url, statusCode)
+	}
+	return nil
+}
+
+// Clear removes all the cached responses,
+// note that a store which is shared between many handlers is cleared for all of them.
+func (h *Handler) Clear() {
+	server.Clear(h.entries)
+}
+
+// ClearExpired removes the expired cached responses right now, besides the store's GCInterval scan,
+// i.e before a heap snapshot, and returns their number. The responses which can still be served as stale are kept.
+// Note that a store which is shared between many handlers is swept for all of them.
+func (h *Handler) ClearExpired() int {
+	return server.ClearExpired(h.entries)
+}
+
+// requestURIKey returns the cache key of the GET requests of the "requestURI",
+// which may be prefixed by the host, see KeyByHost, and by the key's prefix, see KeyPrefixHeader.
+func (h *Handler) requestURIKey(requestURI string) string {
+	prefix := ""
+	if i := strings.IndexByte(requestURI, '|'); h.keyPrefixHeader != "" && i >= 0 {
+		if j := strings.IndexByte(requestURI, '/'); j < 0 || i < j {
+			prefix, requestURI = requestURI[:i+1], requestURI[i+1:]
+		}
+	}
+	host := ""
+	if i := strings.IndexByte(requestURI, '/'); h.keyByHost && i > 0 {
+		host, requestURI = requestURI[:i], requestURI[i:]
+	}
+	return fasthttp.MethodGet + prefix + host + h.normalize(getRequestURIKey(requestURI, h.queryParams, h.ignoredQueryParams))
+}
+
+// Refreshing reports whether the original handler is executing right now
+// to store, renew or revalidate the cached GET response of the "requestURI", see Invalidate,
+// useful to diagnose the stuck refreshes, i.e of an original handler which hangs.
+// It doesn't wait for the execution.
+func (h *Handler) Refreshing(requestURI string) bool {
+	key := h.requestURIKey(requestURI)
+	h.refreshingMu.Lock()
+	n := h.refreshing[key]
+	h.refreshingMu.Unlock()
+	return n > 0
+}
+
+// beginRefresh marks the "key" as refreshing, until the returned func is called.
+func (h *Handler) beginRefresh(key string) func() {
+	h.refreshingMu.Lock()
+	if h.refreshing == nil {
+		h.refreshing = make(map[string]int)
+	}
+	h.refreshing[key]++
+	h.refreshingMu.Unlock()
+
+	return func() {
+		h.refreshingMu.Lock()
+		if h.refreshing[key]--; h.refreshing[key] <= 0 {
+			delete(h.refreshing, key)
+		}
+		h.refreshingMu.Unlock()
+	}
+}
+
+// InvalidateOn returns a middleware for the mutating handlers, i.e the "POST /articles/42" one,
+// which invalidates the cached GET responses of the "keysFn" request uris, i.e "/articles/42" and "/articles",
+// after the mutating handler responds with a 2xx status code.
+// The requests of the other than the "methods" methods are passed through.
+func (h *Handler) InvalidateOn(methods []string, keysFn func(*fasthttp.RequestCtx) []string) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(reqCtx *fasthttp.RequestCtx) {
+			next(reqCtx)
+			if !containsMethod(methods, string(reqCtx.Method())) {
+				return
+			}
+
+			if statusCode := reqCtx.Response.StatusCode(); statusCode >= 200 && statusCode < 300 {
+				for _, requestURI := range keysFn(reqCtx) {
+					h.Invalidate(requestURI)
+				}
+			}
+		}
+	}
+}
+
+// Store sets the store which keeps the cache entries of this handler,
+// it can be shared between many handlers and the remote cache server's one,
+// the handlers which serve the same paths share their cached responses too.
+// If "store" is nil then a new memory store is used instead, the default.
+//
+// It should be called before the handler starts serving.
+//
+// returns itself.
+func (h *Handler) Store(store server.Store) *Handler {
+	if h.defaultEntries {
+		// stop the scan of the replaced memory store.
+		h.GCInterval(0)
+	}
+
+	h.defaultEntries = store == nil
+	if store == nil {
+		store = server.NewMemoryStore()
+		atomic.StoreUint32(&h.gcStarted, 0)
+	}
+	h.entries = store
+	return h
+}
+
+// startGC starts the expired entries scan of the default memory store, once,
+// it's called after each stored response, see GCInterval.
+func (h *Handler) startGC() {
+	if h.defaultEntries && atomic.CompareAndSwapUint32(&h.gcStarted, 0, 1) {
+		h.entries.(server.GarbageCollector).SetGCInterval(defaultGCInterval(h.life))
+	}
+}
+
+// Close stops the expired entries scan of the handler's default memory store, if it's running,
+// the cached responses are kept and they are still served.
+// A store which is set by the Store is not closed, it may be shared, close it by itself.
+func (h *Handler) Close() error {
+	if h.defaultEntries {
+		h.GCInterval(0)
+	}
+	return nil
+}
+
+// GetStore returns the store which keeps the cache entries of this handler, see Store,
+// i.e to inspect it or to share it with other handlers and the remote cache server.
+func (h *Handler) GetStore() server.Store {
+	return h.entries
+}
+
+// getEntry returns the cache entry of the "key", an empty one if not exists,
+// and the store's generation, if it's a server.Generational one.
+// The returned entry may be shared with other requests, it must not be modified,
+// the store builds a new entry for each new response instead.
+func (h *Handler) getEntry(key string) (*entry.Entry, uint64) {
+	var generation uint64
+	// read the generation first, an entry which is cleared after that should not be saved back.
+	if g, ok := h.entries.(server.Generational); ok {
+		generation = g.Generation()
+	}
+
+	if e := h.entries.Get(key); e != nil {
+		return e, generation
+	}
+	return entry.NewEntryMinimum(h.life, h.minimumLife), generation
+}
+
+// swapEntry saves the "e" copy of the "old" entry, i.e a touched one,
+// only if the store still holds the "old" one, a newer response is not overwritten,
+// returns false then. See server.Swapper.
+func (h *Handler) swapEntry(key string, generation uint64, old, e *entry.Entry) bool {
+	if s, ok := h.entries.(server.Swapper); ok {
+		return s.SwapEntry(key, old, e)
+	}
+	return h.putEntry(key, generation, e)
+}
+
+// putEntry saves the "e" entry, with its current response, to the store,
+// it's discarded if the store has been cleared since the "generation" of the getEntry,
+// returns false then.
+func (h *Handler) putEntry(key string, generation uint64, e *entry.Entry) bool {
+	if g, ok := h.entries.(server.Generational); ok {
+		return g.SetEntryIf(generation, key, e)
+	}
+
+	if s, ok := h.entries.(server.CheckedSetter); ok {
+		if err := s.TrySetEntry(key, e); err != nil {
+			// fail-open, the response is already served.
+			h.logger.Printf("httpcache: store %s: %v", key, err)
+			return false
+		}
+		return true
+	}
+
+	if s, ok := h.entries.(server.EntrySetter); ok {
+		s.SetEntry(key, e)
+		return true
+	}
+
+	res, ok := e.Response()
+	if !ok {
+		return false
+	}
+	h.entries.Set(key, res.StatusCode(), res.ContentType(), res.Body(), time.Until(e.ExpiresAt()))
+	return true
+}
+
+// CacheStatusHeader enables a response header which tells
+// if the response was served from the cache, with a value of "HIT",
+// or by the original handler, with a value of "MISS".
+// If "key" is empty then the cfg.CacheStatusHeader is used instead.
+//
+// The header is not part of the cached response.
+//
+// returns itself.
+func (h *Handler) CacheStatusHeader(key string) *Handler {
+	if key == "" {
+		key = cfg.CacheStatusHeader
+	}
+	h.cacheStatusHeader = key
+	return h
+}
+
+// Directive sets the behavior of a response's "Cache-Control" directive,
+// i.e Directive("no-store", ruleset.SkipBehavior) doesn't store responses with "Cache-Control: no-store".
+// Defaults to the ruleset.DefaultDirectives, "no-cache" responses are stored but they are revalidated,
+// the "no-store", the "private" and the zero max age ones, see ruleset.ZeroMaxAgeDirective, are not stored,
+// i.e Directive("private", ruleset.StoreBehavior) stores the "private" responses of a single-user deployment.
+//
+// returns itself.
+func (h *Handler) Directive(directive string, b ruleset.Behavior) *Handler {
+	directives := make(map[string]ruleset.Behavior, len(h.directives)+1)
+	for k, v := range h.directives {
+		directives[k] = v
+	}
+	directives[strings.ToLower(directive)] = b
+	h.directives = directives
+	return h
+}
+
+// ServeHTTP serves the cached response of the "reqCtx" request, if any,
+// otherwise it executes the original handler and it stores its response.
+// The cache key is built by the request's method, url and headers only, the IdempotencyKey's too,
+// so the request's body is never touched by the cache, only the original handler reads it on the cache misses.
+func (h *Handler) ServeHTTP(reqCtx *fasthttp.RequestCtx) {
+
+	// check for pre-cache validators, if at least one of them return false
+	// for this specific request, then skip the whole cache
+	if !h.enabled() || !h.rule.Claim(reqCtx) {
+		h.bodyHandler(reqCtx)
+		return
+	}
+
+	key, ok := h.cacheKey(reqCtx)
+	if !ok {
+		h.bodyHandler(reqCtx)
+		return
+	}
+	e, generation := h.getEntry(key)
+	// check if we have a stored response( it is not expired)
+	res, exists := e.Response()
+	if reqCtx.IsHead() && (!exists || res.Revalidate()) {
+		// the HEAD responses are not cached,
+		// they are served by the cached GET responses only,
+		// fasthttp skips their body but keeps its Content-Length.
+		h.bodyHandler(reqCtx)
+		return
+	}
+
+	if exists && h.refreshRequested(reqCtx) {
+		// the original handler is executed and its response replaces the stored one.
+		exists = false
+	}
+
+	if exists && res.Revalidate() {
+		// the stored response can be reused only if the original handler says so.
+		exists = h.revalidate(key, generation, reqCtx, res)
+		if !exists {
+			// the new response is already there.
+			return
+		}
+	}
+
+	if !exists {
+		if stale, ok := e.Stale(); ok {
+			// the expired response is served if the original handler fails.
+			h.refresh(key, generation, reqCtx, stale)
+			return
+		}
+
+		if !h.acquireOrigin(reqCtx) {
+			// too many executions of the original handler.
+			h.serveOverloaded(reqCtx)
+			return
+		}
+		defer h.releaseOrigin()
+		defer h.beginRefresh(key)()
+		h.logger.Printf("httpcache: miss %s", key)
+
+		// if it's not valid then execute the original handler
+		span := startSpan(h.tracer, cfg.OriginSpanName, key)
+		start := time.Now()
+		if err := serveOrigin(h.bodyHandler, reqCtx); err != nil {
+			endSpan(span, false, false)
+			h.panicHandler(reqCtx, err)
+			return
+		}
+		if h.cacheStatusHeader != "" {
+			reqCtx.Response.Header.Set(h.cacheStatusHeader, cfg.CacheStatusMiss)
+		}
+		if h.slowerThan > 0 && time.Since(start) < h.slowerThan {
+			// too fast to be cached.
+			endSpan(span, false, false)
+			return
+		}
+
+		endSpan(span, false, h.store(key, generation, reqCtx))
+		return
+	}
+
+	if h.slidingExpiration {
+		// the stored entry may be served meanwhile, touch a copy of it.
+		touched := e.Copy()
+		touched.Touch()
+		if h.swapEntry(key, generation, e, touched) {
+			e = touched
+		}
+	}
+
+	h.logger.Printf("httpcache: hit %s", key)
+
+	// if it's valid then just write the cached results
+	if h.cacheStatusHeader != "" {
+		reqCtx.Response.Header.Set(h.cacheStatusHeader, cfg.CacheStatusHit)
+	}
+	if h.hitCacheControl != nil && !res.Revalidate() {
+		reqCtx.Response.Header.Set("Cache-Control", h.hitCacheControl(time.Until(e.ExpiresAt())))
+		reqCtx.Response.Header.Set("Expires", string(fasthttp.AppendHTTPDate(nil, e.ExpiresAt())))
+	}
+
+	if res.Revalidate() && res.NotModified(string(reqCtx.Request.Header.Peek("If-None-Match")),
+		string(reqCtx.Request.Header.Peek("If-Modified-Since"))) {
+		// the client has the same response already, send its validators.
+		setValidators(reqCtx, res)
+		reqCtx.SetStatusCode(fasthttp.StatusNotModified)
+		return
+	}
+
+	setHeader(reqCtx, res)
+	if res.Revalidate() {
+		setValidators(reqCtx, res)
+	}
+	body := h.servedBody(reqCtx, res)
+	if serveRange(reqCtx, res, body) {
+		return
+	}
+
+	reqCtx.SetStatusCode(res.StatusCode())
+	reqCtx.SetContentType(res.ContentType())
+	reqCtx.SetBody(body)
+}
+
+var (
+	bytesRangePrefix = []byte("bytes=")
+	rangeSeparator   = []byte(",")
+)
+
+// serveRange serves the requested byte ranges of the cached response's "body",
+// with a 206 status code, as a "multipart/byteranges" body if more than one range is satisfiable,
+// or a 416 status code if none of them is satisfiable.
+// The whole body is served instead if the ranges sum up to more than its size.
+//
+// Returns false if the request has no valid "Range" header, then nothing is written.
+func serveRange(reqCtx *fasthttp.RequestCtx, res *entry.Response, body []byte) bool {
+	byteRange := reqCtx.Request.Header.Peek("Range")
+	if len(byteRange) == 0 || res.StatusCode() != fasthttp.StatusOK || !bytes.HasPrefix(byteRange, bytesRangePrefix) {
+		return false
+	}
+
+	reqCtx.Response.Header.Set("Accept-Ranges", "bytes")
+	var (
+		ranges [][2]int
+		size   int
+	)
+	for _, spec := range bytes.Split(byteRange[len(bytesRangePrefix):], rangeSeparator) {
+		if spec = bytes.TrimSpace(spec); len(spec) == 0 {
+			continue
+		}
+		start, end, err := fasthttp.ParseByteRange(append(append([]byte(nil), bytesRangePrefix...), spec...), len(body))
+		if err != nil {
+			// not satisfiable, the rest of them may be.
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+		size += end - start + 1
+	}
+
+	switch {
+	case len(ranges) == 0:
+		reqCtx.Response.Header.Set("Content-Range", "bytes */"+strconv.Itoa(len(body)))
+		reqCtx.SetStatusCode(fasthttp.StatusRequestedRangeNotSatisfiable)
+		return true
+	case size > len(body):
+		// overlapping ranges, don't serve more than the body.
+		return false
+	case len(ranges) == 1:
+		start, end := ranges[0][0], ranges[0][1]
+		reqCtx.Response.Header.SetContentRange(start, end, len(body))
+		reqCtx.SetStatusCode(fasthttp.StatusPartialContent)
+		reqCtx.SetContentType(res.ContentType())
+		reqCtx.SetBody(body[start : end+1])
+		return true
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, r := range ranges {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {res.ContentType()},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", r[0], r[1], len(body))},
+		})
+		if err != nil {
+			return false
+		}
+		part.Write(body[r[0] : r[1]+1])
+	}
+	w.Close()
+
+	reqCtx.SetStatusCode(fasthttp.StatusPartialContent)
+	reqCtx.SetContentType("multipart/byteranges; boundary=" + w.Boundary())
+	reqCtx.SetBody(buf.Bytes())
+	return true
+}
+
+// store saves the original handler's response to a new entry of the "key",
+// if it's valid to be stored, returns true if it's stored.
+// The previous entry is replaced, not modified, it may be served to other requests meanwhile.
+func (h *Handler) store(key string, generation uint64, reqCtx *fasthttp.RequestCtx) bool {
+	// remove the TTL header even if the response is not cached.
+	ttl, hasTTL := h.responseTTL(reqCtx)
+
+	// check if it's a valid response, if it's not then just return.
+	if !h.rule.Valid(reqCtx) {
+		return false
+	}
+	if !h.allowSetCookie && !SetCookieRule.Valid(reqCtx) {
+		return false
+	}
+	if !NoCacheRule.Valid(reqCtx) {
+		return false
+	}
+
+	behavior := ruleset.CacheControlBehavior(string(reqCtx.Response.Header.Peek("Cache-Control")), h.directives)
+	if behavior == ruleset.SkipBehavior {
+		return false
+	}
+
+	statusCode := reqCtx.Response.StatusCode()
+	if h.statusKey != nil {
+		// the response is stored as the variant of its actual status code, see KeyByStatus.
+		if variant := statusVariantKey(key, statusCode); variant != key {
+			key = variant
+		}
+	}
+	// the redirects are cached with their "Location" header, even without a body.
+	location := ""
+	if isRedirect(statusCode) {
+		location = string(reqCtx.Response.Header.Peek("Location"))
+	}
+
+	// the preflight responses are cached with their CORS headers, even without a body.
+	preflight := h.preflight(reqCtx)
+
+	body := reqCtx.Response.Body()
+	if (len(body) == 0 && location == "" && !preflight) || (h.maxBodySize > 0 && len(body) > h.maxBodySize) {
+		// if no body or it's too big then just exit,
+		// the response is already written to the client as it's, i.e a 204, it's just not cached.
+		return false
+	}
+	// the response's buffer is reused by the next request of the pooled context, copy it.
+	body = append([]byte(nil), body...)
+	if h.storeTransform != nil {
+		if body = h.storeTransform(body); len(body) == 0 && location == "" && !preflight {
+			return false
+		}
+	}
+
+	// and re-new the entry's response with the new data
+	contentType := getContentType(reqCtx)
+
+	e := entry.NewEntryMinimum(h.life, h.minimumLife)
+	if hasTTL {
+		e.ResetLifetime(statusCode, contentType, body, ttl)
+	} else if h.expiration != nil {
+		life := h.expiration(statusCode, contentType, body)
+		if life <= 0 {
+			return false
+		}
+		e.ResetLifetime(statusCode, contentType, body, life)
+	} else if statusCode == fasthttp.StatusNotFound && h.notFoundLife >= 0 {
+		// 404 responses have their own lifetime, if any.
+		if h.notFoundLife == 0 {
+			return false
+		}
+		e.ResetLifetime(statusCode, contentType, body, h.notFoundLife)
+	} else if ttl, ok := h.ttlPatterns.Match(string(reqCtx.Path())); ok {
+		e.ResetLifetime(statusCode, contentType, body, ttl)
+	} else {
+		if e.LifeTime() <= 0 && expiredResponse(reqCtx) {
+			// the lifetime is taken by the headers and the response is already expired.
+			return false
+		}
+		// check for an expiration time if the
+		// given expiration was not valid &
+		// update the response & release the recorder
+		e.Reset(statusCode, contentType, body, GetResponseMaxAge(reqCtx))
+	}
+
+	if behavior == ruleset.RevalidateBehavior {
+		e.Revalidate(string(reqCtx.Response.Header.Peek("ETag")),
+			string(reqCtx.Response.Header.Peek("Last-Modified")))
+	}
+
+	staleIfError := h.staleIfError
+	if seconds := entry.ParseStaleIfError(string(reqCtx.Response.Header.Peek("Cache-Control"))); seconds >= 0 {
+		staleIfError = time.Duration(seconds) * time.Second
+	}
+	e.StaleIfError(staleIfError)
+	e.SetContentEncoding(string(reqCtx.Response.Header.Peek("Content-Encoding")))
+	if entry.ParseMustRevalidate(string(reqCtx.Response.Header.Peek("Cache-Control"))) {
+		e.MustRevalidate()
+	}
+
+	header := make(map[string][]string)
+	if h.cacheHeaders {
+		statusHeader := string(fasthttp.AppendNormalizedHeaderKey(nil, h.cacheStatusHeader))
+		connection := string(reqCtx.Response.Header.Peek("Connection"))
+		reqCtx.Response.Header.VisitAll(func(k, v []byte) {
+			if key := string(k); entry.CacheableHeader(key, connection) && key != statusHeader {
+				header[key] = append(header[key], string(v))
+			}
+		})
+	}
+	if preflight && !h.cacheHeaders {
+		reqCtx.Response.Header.VisitAll(func(k, v []byte) {
+			if key := string(k); entry.CORSHeader(key) {
+				header[key] = append(header[key], string(v))
+			}
+		})
+	}
+	if h.cacheCookies {
+		var cookies []string
+		// the whole "Set-Cookie" header value of each cookie.
+		reqCtx.Response.Header.VisitAllCookie(func(_, value []byte) {
+			cookies = append(cookies, string(value))
+		})
+		if len(cookies) > 0 {
+			header["Set-Cookie"] = cookies
+		}
+	}
+	if location != "" {
+		header["Location"] = []string{location}
+	}
+	if len(header) > 0 {
+		e.SetHeader(header)
+	}
+
+	stored := h.putEntry(key, generation, e)
+	if stored {
+		h.startGC()
+		h.logger.Printf("httpcache: stored %s, expires at %s", key, e.ExpiresAt().Format(time.RFC3339))
+	}
+	return stored
+}
+
+// refresh executes the original handler to renew the expired "stale" response,
+// if the original handler fails, with a 5xx status code or a panic,
+// then the "stale" response is served instead, with a "Warning" header.
+// Otherwise the new response is kept and it's stored.
+func (h *Handler) refresh(key string, generation uint64, reqCtx *fasthttp.RequestCtx, stale *entry.Response) {
+	if !h.acquireOrigin(reqCtx) {
+		// too many executions of the original handler.
+		h.serveStale(reqCtx, stale)
+		return
+	}
+	defer h.releaseOrigin()
+	defer h.beginRefresh(key)()
+
+	span := startSpan(h.tracer, cfg.OriginSpanName, key)
+	start := time.Now()
+	if err := serveOrigin(h.bodyHandler, reqCtx); err != nil || reqCtx.Response.StatusCode() >= fasthttp.StatusInternalServerError {
+		endSpan(span, false, false)
+		// forget the failed response.
+		reqCtx.Response.Reset()
+		h.serveStale(reqCtx, stale)
+		return
+	}
+
+	if h.cacheStatusHeader != "" {
+		reqCtx.Response.Header.Set(h.cacheStatusHeader, cfg.CacheStatusMiss)
+	}
+	if h.slowerThan > 0 && time.Since(start) < h.slowerThan {
+		// too fast to be cached.
+		endSpan(span, false, false)
+		return
+	}
+	endSpan(span, false, h.store(key, generation, reqCtx))
+}
+
+// serveStale writes the expired "stale" response, with a "Warning" header.
+func (h *Handler) serveStale(reqCtx *fasthttp.RequestCtx, stale *entry.Response) {
+	if h.cacheStatusHeader != "" {
+		reqCtx.Response.Header.Set(h.cacheStatusHeader, cfg.CacheStatusStale)
+	}
+	reqCtx.Response.Header.Set("Warning", cfg.StaleWarning)
+	reqCtx.SetStatusCode(stale.StatusCode())
+	reqCtx.SetContentType(stale.ContentType())
+	setHeader(reqCtx, stale)
+	reqCtx.SetBody(h.servedBody(reqCtx, stale))
+}
+
+// revalidate executes the original handler with the "If-None-Match" and "If-Modified-Since"
+// headers of the stored response, if the original handler responds with a 304 status code
+// then the stored response is still valid and it returns true, the caller should serve it.
+// Otherwise the new response is kept, it's stored and it returns false.
+func (h *Handler) revalidate(key string, generation uint64, reqCtx *fasthttp.RequestCtx, res *entry.Response) bool {
+	if !h.acquireOrigin(reqCtx) {
+		// too many executions of the original handler.
+		h.serveOverloaded(reqCtx)
+		return false
+	}
+	defer h.releaseOrigin()
+	defer h.beginRefresh(key)()
+
+	// keep the client's conditional headers, they are restored after the execution.
+	ifNoneMatch := string(reqCtx.Request.Header.Peek("If-None-Match"))
+	ifModifiedSince := string(reqCtx.Request.Header.Peek("If-Modified-Since"))
+	reqCtx.Request.Header.Del("If-None-Match")
+	reqCtx.Request.Header.Del("If-Modified-Since")
+	if etag := res.ETag(); etag != "" {
+		reqCtx.Request.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := res.LastModified(); lastModified != "" {
+		reqCtx.Request.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	span := startSpan(h.tracer, cfg.OriginSpanName, key)
+	panicked := serveOrigin(h.bodyHandler, reqCtx)
+
+	reqCtx.Request.Header.Del("If-None-Match")
+	reqCtx.Request.Header.Del("If-Modified-Since")
+	if ifNoneMatch != "" {
+		reqCtx.Request.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	if ifModifiedSince != "" {
+		reqCtx.Request.Header.Set("If-Modified-Since", ifModifiedSince)
+	}
+
+	if panicked != nil {
+		endSpan(span, false, false)
+		h.panicHandler(reqCtx, panicked)
+		return false
+	}
+
+	if reqCtx.Response.StatusCode() == fasthttp.StatusNotModified {
+		// forget the 304 response, the stored one will be served instead.
+		reqCtx.Response.Reset()
+		endSpan(span, true, false)
+		return true
+	}
+
+	if h.cacheStatusHeader != "" {
+		reqCtx.Response.Header.Set(h.cacheStatusHeader, cfg.CacheStatusMiss)
+	}
+	endSpan(span, false, h.store(key, generation, reqCtx))
+	return false
+}
+
+// SetEnabled enables or disables the caching of this handler at runtime,
+// a disabled handler executes the original handler, nothing is served from or stored to the cache,
+// the existing entries are kept, so it can be re-enabled instantly.
+// It's safe for concurrent use, see cfg.SetEnabled for all the handlers.
+//
+// The caching is enabled by default.
+//
+// returns itself.
+func (h *Handler) SetEnabled(enabled bool) *Handler {
+	var v uint32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&h.disabled, v)
+	return h
+}
+
+// enabled reports whether the caching is enabled for this handler and globally.
+func (h *Handler) enabled() bool {
+	return cfg.Enabled() && atomic.LoadUint32(&h.disabled) == 0
 }
@@ -0,0 +1,30 @@
+package fhttp
+
+import (
+	"log"
+
+	"github.com/valyala/fasthttp"
+)
+
+// PanicHandler handles a panic of the original handler,
+// "err" is the recovered value, see Handler.OnPanic.
+type PanicHandler func(reqCtx *fasthttp.RequestCtx, err interface{})
+
+// DefaultPanicHandler logs the panic and responds with a 500 status code.
+var DefaultPanicHandler PanicHandler = func(reqCtx *fasthttp.RequestCtx, err interface{}) {
+	log.Printf("httpcache: recovered from a panic of the %s %s handler: %v", reqCtx.Method(), reqCtx.RequestURI(), err)
+	// forget the partial response.
+	reqCtx.Response.Reset()
+	reqCtx.SetStatusCode(fasthttp.StatusInternalServerError)
+}
+
+// serveOrigin executes the "handler",
+// it returns the recovered value if the "handler" panics, otherwise nil.
+func serveOrigin(handler fasthttp.RequestHandler, reqCtx *fasthttp.RequestCtx) (err interface{}) {
+	defer func() {
+		err = recover()
+	}()
+
+	handler(reqCtx)
+	return nil
+}
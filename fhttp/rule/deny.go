@@ -0,0 +1,31 @@
+package rule
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// denyRule is a Rule which denies the requests that at least one of its deniers returns true for.
+type denyRule struct {
+	deniers []func(*fasthttp.RequestCtx) bool
+}
+
+var _ Rule = &denyRule{}
+
+// Deny returns a new rule which denies the requests that at least one of the "deniers" returns true for,
+// the original handler serves them as it's, without the cache.
+func Deny(deniers ...func(*fasthttp.RequestCtx) bool) Rule {
+	return &denyRule{deniers: deniers}
+}
+
+func (d *denyRule) Claim(reqCtx *fasthttp.RequestCtx) bool {
+	for _, deny := range d.deniers {
+		if deny(reqCtx) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *denyRule) Valid(*fasthttp.RequestCtx) bool {
+	return true
+}
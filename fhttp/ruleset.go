@@ -26,11 +26,23 @@ var DefaultRuleSet = rule.Chained(
 	// server.
 	rule.HeaderClaim(ruleset.MustRevalidateRule),
 	rule.HeaderClaim(ruleset.ZeroMaxAgeRule),
+	// the legacy HTTP/1.0 clients force a fresh response with a "Pragma: no-cache" header,
+	// the original handler serves them.
+	rule.HeaderClaim(ruleset.PragmaNoCacheRule),
 	// #3 custom No-Cache header used inside this library
-	// for BOTH request and response (after get-cache action)
-	rule.Header(ruleset.NoCacheRule, ruleset.NoCacheRule),
+	// for BOTH request and response (after get-cache action),
+	// the responses are checked by the NoCacheRule too, whatever the rules are.
+	rule.Header(ruleset.NoCacheRule, ruleset.NoCacheResponseRule),
 )
 
+// SetCookieRule is the post-cache validator which denies the responses that set cookies,
+// it's executed by ALL handlers, local and remote, unless their AllowSetCookie is called.
+var SetCookieRule = rule.HeaderValid(ruleset.SetCookieRule)
+
+// NoCacheRule is the post-cache validator which denies the responses that are marked by the NoCache,
+// it's executed by ALL handlers, local and remote, even if their rules don't include the DefaultRuleSet.
+var NoCacheRule = rule.HeaderValid(ruleset.NoCacheResponseRule)
+
 // NoCache called when a particular handler is not valid for cache.
 // If this function called inside a handler then the handler is not cached.
 func NoCache(reqCtx *fasthttp.RequestCtx) {
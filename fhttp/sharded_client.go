@@ -0,0 +1,80 @@
+package fhttp
+
+import (
+	"time"
+
+	"github.com/geekypanda/httpcache/uri"
+	"github.com/valyala/fasthttp"
+)
+
+// ShardedClientHandler is the client-side handler of many remote cache servers,
+// it routes each request to one of them by its cache key with consistent hashing,
+// this way the keys are distributed between the servers and when a server fails
+// only the cached responses of its own slice are lost, see uri.Ring.
+//
+// Each server is called by its own ClientHandler, see Configure.
+type ShardedClientHandler struct {
+	bodyHandler fasthttp.RequestHandler
+	ring        *uri.Ring
+	nodes       map[string]*ClientHandler
+	// keyNode computes the cache keys which are hashed,
+	// the nodes share their configuration so any of them can compute them.
+	keyNode *ClientHandler
+}
+
+// NewShardedClientHandler returns a new client handler of the "remotes" cache servers,
+// each one of them is called by a ClientHandler, see NewClientHandler.
+// If there is no remote server then the "bodyHandler" is always executed.
+func NewShardedClientHandler(bodyHandler fasthttp.RequestHandler, life time.Duration, remotes []string) *ShardedClientHandler {
+	h := &ShardedClientHandler{
+		bodyHandler: bodyHandler,
+		ring:        uri.NewRing(remotes, 0),
+		nodes:       make(map[string]*ClientHandler, len(remotes)),
+	}
+
+	for _, remote := range remotes {
+		if _, exists := h.nodes[remote]; exists {
+			continue
+		}
+		node := NewClientHandler(bodyHandler, life, remote)
+		h.nodes[remote] = node
+		if h.keyNode == nil {
+			h.keyNode = node
+		}
+	}
+
+	return h
+}
+
+// Configure calls the "fn" with the ClientHandler of each remote cache server,
+// i.e to set their rules or their key options, they should be configured the same way,
+// so a request is routed to the same server whichever of them computes its cache key.
+//
+// It should be called before the handler starts serving.
+//
+// returns itself.
+func (h *ShardedClientHandler) Configure(fn func(*ClientHandler)) *ShardedClientHandler {
+	for _, node := range h.nodes {
+		fn(node)
+	}
+	return h
+}
+
+// Node returns the ClientHandler of the remote cache server which keeps the cached response
+// of the "reqCtx" request, returns nil if there is no remote server.
+func (h *ShardedClientHandler) Node(reqCtx *fasthttp.RequestCtx) *ClientHandler {
+	if h.keyNode == nil {
+		return nil
+	}
+	return h.nodes[h.ring.Get(getCacheMethod(reqCtx)+h.keyNode.cacheKey(reqCtx))]
+}
+
+// ServeHTTP serves the "reqCtx" request by the ClientHandler of its remote cache server, see Node.
+func (h *ShardedClientHandler) ServeHTTP(reqCtx *fasthttp.RequestCtx) {
+	node := h.Node(reqCtx)
+	if node == nil {
+		h.bodyHandler(reqCtx)
+		return
+	}
+	node.ServeHTTP(reqCtx)
+}
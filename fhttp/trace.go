@@ -0,0 +1,32 @@
+package fhttp
+
+import (
+	"context"
+
+	"github.com/geekypanda/httpcache/cfg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts a new root span of the "tracer", annotated with the cache key,
+// fasthttp's request context doesn't carry a parent span.
+// It returns a nil span if the "tracer" is nil, this way there is no overhead when tracing is disabled.
+func startSpan(tracer trace.Tracer, name string, key string) trace.Span {
+	if tracer == nil {
+		return nil
+	}
+
+	_, span := tracer.Start(context.Background(), name, trace.WithAttributes(attribute.String(cfg.SpanKeyAttribute, key)))
+	return span
+}
+
+// endSpan annotates the "span" with the hit and stored results and ends it,
+// if the "span" is nil then it does nothing.
+func endSpan(span trace.Span, hit bool, stored bool) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttributes(attribute.Bool(cfg.SpanHitAttribute, hit), attribute.Bool(cfg.SpanStoredAttribute, stored))
+	span.End()
+}
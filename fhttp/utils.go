@@ -1,9 +1,13 @@
 package fhttp
 
 import (
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/geekypanda/httpcache/entry"
+	"github.com/geekypanda/httpcache/uri"
 	"github.com/valyala/fasthttp"
 )
 
@@ -18,3 +22,192 @@ func GetMaxAge(reqCtx *fasthttp.RequestCtx) entry.LifeChanger {
 		return time.Duration(headerCacheDur) * time.Second
 	}
 }
+
+// GetResponseMaxAge parses the response's "Cache-Control" header
+// and returns a LifeChanger which can be passed
+// to the response's Reset,
+// if the response has no max age then its "Expires" header is used instead, max-age wins over it,
+// and if it has neither of them then the request's max age is used, see GetMaxAge.
+func GetResponseMaxAge(reqCtx *fasthttp.RequestCtx) entry.LifeChanger {
+	return func() time.Duration {
+		if seconds := entry.ParseMaxAge(string(reqCtx.Response.Header.Peek("Cache-Control"))); seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if expires, ok := entry.ParseExpires(string(reqCtx.Response.Header.Peek("Expires"))); ok && expires > 0 {
+			return expires
+		}
+		return GetMaxAge(reqCtx)()
+	}
+}
+
+// expiredResponse reports whether the response of the "reqCtx" has no max age
+// and its "Expires" header is a past date, then it's already expired.
+func expiredResponse(reqCtx *fasthttp.RequestCtx) bool {
+	if entry.ParseMaxAge(string(reqCtx.Response.Header.Peek("Cache-Control"))) >= 0 {
+		return false
+	}
+	expires, ok := entry.ParseExpires(string(reqCtx.Response.Header.Peek("Expires")))
+	return ok && expires <= 0
+}
+
+// defaultContentType is the content type of the fasthttp responses which didn't set one.
+const defaultContentType = "text/plain; charset=utf-8"
+
+// getContentType returns the response's content type,
+// if the handler didn't set one then it's detected from the first 512 bytes of the body,
+// a "gzip" encoded body is decompressed first,
+// as the net/http's http.ResponseWriter does, instead of the fasthttp's "text/plain" default.
+// The detected content type is set to the response too, so the client of the cache miss
+// receives the same content type as the clients of the cache hits.
+func getContentType(reqCtx *fasthttp.RequestCtx) string {
+	contentType, ok := responseContentType(&reqCtx.Response.Header)
+	if ok {
+		return contentType
+	}
+
+	body := reqCtx.Response.Body()
+	if len(body) == 0 {
+		return defaultContentType
+	}
+	if string(reqCtx.Response.Header.Peek("Content-Encoding")) == "gzip" {
+		if decoded, err := entry.Gunzip(body); err == nil {
+			body = decoded
+		}
+	}
+	if len(body) > 512 {
+		body = body[:512]
+	}
+	contentType = http.DetectContentType(body)
+	reqCtx.Response.Header.SetContentType(contentType)
+	return contentType
+}
+
+// responseContentType returns the content type which the handler has set to the "header",
+// it's false if the handler didn't set one, even if the header's ContentType
+// returns the fasthttp's default one, i.e the handler's "text/plain; charset=utf-8" is kept.
+func responseContentType(header *fasthttp.ResponseHeader) (string, bool) {
+	contentType := string(header.ContentType())
+	if contentType == "" {
+		// the server's NoDefaultContentType is enabled.
+		return "", false
+	}
+	if contentType != defaultContentType {
+		return contentType, true
+	}
+
+	// the default one is returned when it's not set, look for the set one alone.
+	header.SetNoDefaultContentType(true)
+	set := len(header.ContentType()) > 0
+	header.SetNoDefaultContentType(false)
+	return contentType, set
+}
+
+// getCacheMethod returns the request method which participates in the cache key,
+// the HEAD requests share the cached responses of the GET ones.
+func getCacheMethod(reqCtx *fasthttp.RequestCtx) string {
+	if reqCtx.IsHead() {
+		return fasthttp.MethodGet
+	}
+	return string(reqCtx.Method())
+}
+
+// getRequestURIKey returns the cache key of a request uri, i.e "/articles?page=2",
+// see getCacheKey.
+func getRequestURIKey(requestURI string, include, exclude []string) string {
+	key, query := requestURI, ""
+	if i := strings.IndexByte(requestURI, '?'); i >= 0 {
+		key, query = requestURI[:i], requestURI[i+1:]
+	}
+	if query = uri.FilterQuery(query, include, exclude); query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// statusVariantKey returns the cache key of the "statusCode" variant of the "key",
+// which may be the key of another variant already, see Handler.KeyByStatus.
+func statusVariantKey(key string, statusCode int) string {
+	if i := strings.LastIndex(key, "#status="); i >= 0 {
+		key = key[:i]
+	}
+	return key + "#status=" + strconv.Itoa(statusCode)
+}
+
+// containsMethod reports whether the "method" is one of the "methods".
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// getCacheKey returns the cache key of a request,
+// its path and its query filtered by the "include" and "exclude" parameters,
+// see uri.FilterQuery.
+func getCacheKey(reqCtx *fasthttp.RequestCtx, include, exclude []string) string {
+	key := string(reqCtx.URI().PathOriginal())
+	if query := uri.FilterQuery(string(reqCtx.URI().QueryString()), include, exclude); query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// setHeader adds the stored headers of the "res" response to the reqCtx's response,
+// each value as a separate header, the cookies are set one by one, see Handler.CacheCookies and Handler.CacheHeaders.
+// The headers which are already set, i.e by the cache itself, are not replaced.
+func setHeader(reqCtx *fasthttp.RequestCtx, res *entry.Response) {
+	for k, values := range res.Header() {
+		if k != "Set-Cookie" {
+			if len(reqCtx.Response.Header.Peek(k)) > 0 {
+				continue
+			}
+			for _, v := range values {
+				reqCtx.Response.Header.Add(k, v)
+			}
+			continue
+		}
+
+		for _, v := range values {
+			c := fasthttp.AcquireCookie()
+			if c.Parse(v) == nil {
+				reqCtx.Response.Header.SetCookie(c)
+			}
+			fasthttp.ReleaseCookie(c)
+		}
+	}
+}
+
+// isRedirect reports whether the "statusCode" is a redirect one, which is cached with its "Location" header.
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther,
+		fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// setValidators sets the "ETag" and the "Last-Modified" headers of the "res" response, if any.
+func setValidators(reqCtx *fasthttp.RequestCtx, res *entry.Response) {
+	if etag := res.ETag(); etag != "" {
+		reqCtx.Response.Header.Set("ETag", etag)
+	}
+	if lastModified := res.LastModified(); lastModified != "" {
+		reqCtx.Response.Header.Set("Last-Modified", lastModified)
+	}
+}
+
+// defaultGCInterval returns the expired entries scan's interval of the default memory store
+// of a handler with the "life" expiration, it's the "life" duration,
+// or a minute if the lifetimes are taken by the headers, see Handler.GCInterval.
+func defaultGCInterval(life time.Duration) time.Duration {
+	if life <= 0 {
+		return time.Minute
+	}
+	if life < time.Second {
+		return time.Second
+	}
+	return life
+}
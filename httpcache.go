@@ -1,11 +1,16 @@
 package httpcache
 
 import (
+	"github.com/geekypanda/httpcache/cfg"
 	"github.com/geekypanda/httpcache/fhttp"
+	fhttprule "github.com/geekypanda/httpcache/fhttp/rule"
 	"github.com/geekypanda/httpcache/nethttp"
+	"github.com/geekypanda/httpcache/nethttp/rule"
+	"github.com/geekypanda/httpcache/ruleset"
 	"github.com/geekypanda/httpcache/server"
 	"github.com/valyala/fasthttp"
 	"net/http"
+	"regexp"
 	"time"
 )
 
@@ -26,9 +31,21 @@ const (
 //
 // All type of responses are cached, templates, json, text, anything.
 //
-// You can add validators with this function
-func Cache(bodyHandler http.Handler, expiration time.Duration) *nethttp.Handler {
-	return nethttp.NewHandler(bodyHandler, expiration)
+// You can add validators with this function, i.e AddRule or the optional "rules", see Rules,
+// and manage its cached responses, i.e Invalidate and Clear.
+func Cache(bodyHandler http.Handler, expiration time.Duration, rules ...*RuleSet) *nethttp.Handler {
+	h := nethttp.NewHandler(bodyHandler, expiration)
+	for _, r := range rules {
+		h.AddRule(r.Rule())
+	}
+	return h
+}
+
+// CacheWithStore same as Cache but the cache entries are kept to the "store",
+// it can be shared between many handlers and the remote cache server's one,
+// i.e server.NewMemoryStore() or server.NewBoltStore(...).
+func CacheWithStore(bodyHandler http.Handler, expiration time.Duration, store server.Store) *nethttp.Handler {
+	return Cache(bodyHandler, expiration).Store(store)
 }
 
 // CacheFunc accepts two parameters
@@ -39,7 +56,7 @@ func Cache(bodyHandler http.Handler, expiration time.Duration) *nethttp.Handler
 //
 // All type of responses are cached, templates, json, text, anything.
 //
-// You CAN NOT add validators with this function
+// You CAN NOT add validators with this function, use the Cache instead.
 func CacheFunc(bodyHandler func(http.ResponseWriter, *http.Request), expiration time.Duration) http.HandlerFunc {
 	return Cache(http.HandlerFunc(bodyHandler), expiration).ServeHTTP
 }
@@ -53,9 +70,19 @@ func CacheFunc(bodyHandler func(http.ResponseWriter, *http.Request), expiration
 //
 // All type of responses are cached, templates, json, text, anything.
 //
-// You can add validators with this function
-func CacheFasthttp(bodyHandler fasthttp.RequestHandler, expiration time.Duration) *fhttp.Handler {
-	return fhttp.NewHandler(bodyHandler, expiration)
+// You can add validators with this function, i.e AddRule or the optional "rules", see Rules
+func CacheFasthttp(bodyHandler fasthttp.RequestHandler, expiration time.Duration, rules ...*RuleSet) *fhttp.Handler {
+	h := fhttp.NewHandler(bodyHandler, expiration)
+	for _, r := range rules {
+		h.AddRule(r.FasthttpRule())
+	}
+	return h
+}
+
+// CacheFasthttpWithStore same as CacheFasthttp but the cache entries are kept to the "store",
+// it can be shared between many handlers and the remote cache server's one.
+func CacheFasthttpWithStore(bodyHandler fasthttp.RequestHandler, expiration time.Duration, store server.Store) *fhttp.Handler {
+	return CacheFasthttp(bodyHandler, expiration).Store(store)
 }
 
 // CacheFasthttpFunc accepts two parameters
@@ -83,6 +110,13 @@ func ListenAndServe(addr string) error {
 	return server.New(addr, nil).ListenAndServe()
 }
 
+// ListenAndServeWithConfig same as ListenAndServe
+// but the remote server cache handler is bounded by the "c" Config,
+// i.e the maximum body size per entry and the maximum entries.
+func ListenAndServeWithConfig(addr string, c server.Config) error {
+	return server.NewWithConfig(addr, nil, c).ListenAndServe()
+}
+
 // CacheRemote receives a handler, its cache expiration and
 // the remote address of the remote cache server(look ListenAndServe)
 // returns a remote-cached handler
@@ -101,6 +135,16 @@ func CacheRemoteFunc(bodyHandler func(http.ResponseWriter, *http.Request), expir
 	return CacheRemote(http.HandlerFunc(bodyHandler), expiration, remoteServerAddr).ServeHTTP
 }
 
+// CacheRemoteSharded receives a handler, its cache expiration and
+// the remote addresses of many remote cache servers(look ListenAndServe)
+// returns a remote-cached handler which routes each request to one of them
+// by its cache key with consistent hashing
+//
+// You can add validators to each server's client handler with its Configure
+func CacheRemoteSharded(bodyHandler http.Handler, expiration time.Duration, remoteServerAddrs ...string) *nethttp.ShardedClientHandler {
+	return nethttp.NewShardedClientHandler(bodyHandler, expiration, remoteServerAddrs)
+}
+
 // CacheRemoteFasthttp receives a fasthttp handler, its cache expiration and
 // the remote address of the remote cache server(look ListenAndServe)
 // returns a remote-cached handler
@@ -119,14 +163,80 @@ func CacheRemoteFasthttpFunc(bodyHandler fasthttp.RequestHandler, expiration tim
 	return CacheRemoteFasthttp(bodyHandler, expiration, remoteServerAddr).ServeHTTP
 }
 
+// CacheRemoteShardedFasthttp same as CacheRemoteSharded but for a fasthttp handler
+//
+// You can add validators to each server's client handler with its Configure
+func CacheRemoteShardedFasthttp(bodyHandler fasthttp.RequestHandler, expiration time.Duration, remoteServerAddrs ...string) *fhttp.ShardedClientHandler {
+	return fhttp.NewShardedClientHandler(bodyHandler, expiration, remoteServerAddrs)
+}
+
+// InvalidateRemote removes the cached response of the "r" request
+// from the remote cache server(look ListenAndServe),
+// it returns an error if the entry couldn't be removed.
+func InvalidateRemote(remoteServerAddr string, r *http.Request) error {
+	return nethttp.InvalidateRemote(remoteServerAddr, r)
+}
+
+// InvalidateRemoteFasthttp removes the cached response of the "reqCtx" request
+// from the remote cache server(look ListenAndServe),
+// it returns an error if the entry couldn't be removed.
+func InvalidateRemoteFasthttp(remoteServerAddr string, reqCtx *fasthttp.RequestCtx) error {
+	return fhttp.InvalidateRemote(remoteServerAddr, reqCtx)
+}
+
+// SetEnabled enables or disables the caching of all the handlers at runtime,
+// i.e during an incident, the disabled handlers execute the original handlers
+// and they keep their entries, so the caching can be re-enabled instantly.
+// Each handler can be toggled by its own SetEnabled too.
+func SetEnabled(enabled bool) {
+	cfg.SetEnabled(enabled)
+}
+
+// ValidWhenHeader returns a rule which caches only the responses
+// that their "name" header's value matches the "valueRegex",
+// i.e httpcache.Cache(handler, time.Minute).AddRule(httpcache.ValidWhenHeader("X-Cacheable", "^true$")).
+// It panics if the "valueRegex" is not a valid regular expression.
+func ValidWhenHeader(name string, valueRegex string) rule.Rule {
+	return rule.HeaderValid(ruleset.HeaderMatches(name, regexp.MustCompile(valueRegex)))
+}
+
+// ClaimWhenHeader returns a rule which caches only the requests
+// that their "name" header's value matches the "valueRegex", the rest bypass the cache,
+// i.e httpcache.Cache(handler, time.Minute).AddRule(httpcache.ClaimWhenHeader("Accept", "json")).
+// It panics if the "valueRegex" is not a valid regular expression.
+func ClaimWhenHeader(name string, valueRegex string) rule.Rule {
+	return rule.HeaderClaim(ruleset.HeaderMatches(name, regexp.MustCompile(valueRegex)))
+}
+
+// ValidWhenHeaderFasthttp same as ValidWhenHeader but for the fasthttp handlers,
+// i.e httpcache.CacheFasthttp(handler, time.Minute).AddRule(httpcache.ValidWhenHeaderFasthttp("X-Cacheable", "^true$")).
+func ValidWhenHeaderFasthttp(name string, valueRegex string) fhttprule.Rule {
+	return fhttprule.HeaderValid(ruleset.HeaderMatches(name, regexp.MustCompile(valueRegex)))
+}
+
+// ClaimWhenHeaderFasthttp same as ClaimWhenHeader but for the fasthttp handlers.
+func ClaimWhenHeaderFasthttp(name string, valueRegex string) fhttprule.Rule {
+	return fhttprule.HeaderClaim(ruleset.HeaderMatches(name, regexp.MustCompile(valueRegex)))
+}
+
 var (
 	// NoCache called when a particular handler is not valid for cache.
 	// If this function called inside a handler then the handler is not cached
-	// even if it's surrounded with the Cache/CacheFunc/CacheRemote wrappers.
+	// even if it's surrounded with the Cache/CacheFunc/CacheRemote wrappers,
+	// whatever their rules are, see nethttp.NoCacheRule.
 	NoCache = nethttp.NoCache
 
 	// NoCacheFasthttp called when a particular fasthttp RequestHandler is not valid for cache.
 	// If this function called inside a handler then the handler is not cached
 	// even if it's surrounded with the CacheFasthttp/CacheRemoteFasthttp wrapper.
 	NoCacheFasthttp = fhttp.NoCache
+
+	// BypassKey is the request's context key which, with a true value,
+	// makes the net/http handlers to bypass the cache, see WithBypass.
+	BypassKey = nethttp.BypassKey
+
+	// WithBypass returns a copy of a request's context which makes the net/http handlers to bypass the cache,
+	// i.e a preview mode which is decided by a middleware before the cache:
+	// next.ServeHTTP(w, r.WithContext(httpcache.WithBypass(r.Context()))).
+	WithBypass = nethttp.WithBypass
 )
@@ -1,17 +1,42 @@
 package httpcache_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	stdhttptest "net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gavv/httpexpect"
 	"github.com/geekypanda/httpcache"
+	"github.com/geekypanda/httpcache/cfg"
+	"github.com/geekypanda/httpcache/entry"
+	"github.com/geekypanda/httpcache/fhttp"
 	"github.com/geekypanda/httpcache/httptest"
+	"github.com/geekypanda/httpcache/nethttp"
 	"github.com/geekypanda/httpcache/nethttp/rule"
+	"github.com/geekypanda/httpcache/ruleset"
+	"github.com/geekypanda/httpcache/server"
+	"github.com/geekypanda/httpcache/uri"
 	"github.com/kataras/go-errors"
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 var (
@@ -109,6 +134,80 @@ func TestNoCache(t *testing.T) {
 
 }
 
+func TestNoCacheWithoutDefaultDeniers(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		httpcache.NoCache(res)
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).WithoutDefaultDeniers()
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/nocache").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/nocache").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the handler to be executed 2 times but executed %d", got)
+	}
+	if got := cachedHandler.GetStore().Len(); got != 0 {
+		t.Fatalf("expected no stored entries but got %d", got)
+	}
+}
+
+func TestCacheWithDeniers(t *testing.T) {
+	var n, fn uint32
+	newHandler := func() *nethttp.Handler {
+		return httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			atomic.AddUint32(&n, 1)
+			res.Write([]byte(expectedBodyStr))
+		}), cacheDuration)
+	}
+	newFasthttpHandler := func() *fhttp.Handler {
+		return httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+			atomic.AddUint32(&fn, 1)
+			reqCtx.Write([]byte(expectedBodyStr))
+		}, cacheDuration)
+	}
+
+	// only the first handler of each stack denies the preview and the debug requests.
+	denied := newHandler().WithDeniers(func(r *http.Request) bool {
+		return r.Header.Get("X-Preview") != ""
+	}, func(r *http.Request) bool {
+		return r.URL.Query().Get("debug") == "true"
+	})
+	fasthttpDenied := newFasthttpHandler().WithDeniers(func(reqCtx *fasthttp.RequestCtx) bool {
+		return len(reqCtx.Request.Header.Peek("X-Preview")) > 0
+	}, func(reqCtx *fasthttp.RequestCtx) bool {
+		return string(reqCtx.QueryArgs().Peek("debug")) == "true"
+	})
+
+	tests := []struct {
+		e                *httpexpect.Expect
+		counter          *uint32
+		expectedExecuted uint32
+	}{
+		{httptest.New(t, httptest.Handler(denied)), &n, 5},
+		{httptest.New(t, httptest.RequestHandler(fasthttpDenied.ServeHTTP)), &fn, 5},
+		// the rest of the handlers are not affected.
+		{httptest.New(t, httptest.Handler(newHandler())), &n, 3},
+		{httptest.New(t, httptest.RequestHandler(newFasthttpHandler().ServeHTTP)), &fn, 3},
+	}
+
+	for i, tt := range tests {
+		atomic.StoreUint32(tt.counter, 0)
+		tt.e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		tt.e.GET("/").WithHeader("X-Preview", "1").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		tt.e.GET("/").WithQuery("debug", true).Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		tt.e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		// a denied response is not stored either.
+		tt.e.GET("/other").WithHeader("X-Preview", "1").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		tt.e.GET("/other").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+		if got := atomic.LoadUint32(tt.counter); got != tt.expectedExecuted {
+			t.Fatalf("[%d] expected the original handler to be executed %d times but executed %d times", i, tt.expectedExecuted, got)
+		}
+	}
+}
+
 func TestCache(t *testing.T) {
 	mux := http.NewServeMux()
 	var n uint32
@@ -264,3 +363,3218 @@ func TestCacheValidator(t *testing.T) {
 		t.Fatal(errTestFailed.Format(3, counter))
 	}
 }
+
+func TestCacheStatusHeader(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).CacheStatusHeader("")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Header("X-Cache").Equal("MISS")
+	e.GET("/").Expect().Status(http.StatusOK).Header("X-Cache").Equal("HIT")
+	e.GET("/").WithHeader("Authorization", "basic or anything").Expect().Status(http.StatusOK).Header("X-Cache").Empty()
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 2 {
+		t.Fatal(errTestFailed.Format(2, counter))
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set("ETag", `"v1"`)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	// stored but revalidated by the original handler on each request
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	notModified := e.GET("/").WithHeader("If-None-Match", `"v1"`).Expect().Status(http.StatusNotModified)
+	notModified.Body().Empty()
+	notModified.Header("ETag").Equal(`"v1"`)
+	// weak comparison.
+	e.GET("/").WithHeader("If-None-Match", `"v0", W/"v1"`).Expect().Status(http.StatusNotModified).Body().Empty()
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 4 {
+		t.Fatal(errTestFailed.Format(4, counter))
+	}
+}
+
+func TestCacheRange(t *testing.T) {
+	cachedHandler := httpcache.CacheFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}, cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").WithHeader("Range", "bytes=0-6").Expect().Status(http.StatusPartialContent).Body().Equal(expectedBodyStr[0:7])
+	e.GET("/").WithHeader("Range", "bytes=1000-").Expect().Status(http.StatusRequestedRangeNotSatisfiable)
+}
+
+func TestCacheFasthttpMultiRange(t *testing.T) {
+	cachedHandler := httpcache.CacheFasthttpFunc(func(reqCtx *fasthttp.RequestCtx) {
+		reqCtx.Write([]byte(expectedBodyStr))
+	}, cacheDuration)
+
+	e := httptest.New(t, httptest.RequestHandler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").WithHeader("Range", "bytes=0-6").Expect().Status(http.StatusPartialContent).Body().Equal(expectedBodyStr[0:7])
+	e.GET("/").WithHeader("Range", "bytes=1000-, 2000-").Expect().Status(http.StatusRequestedRangeNotSatisfiable)
+	// the unsatisfiable ones are skipped.
+	e.GET("/").WithHeader("Range", "bytes=0-6, 1000-").Expect().Status(http.StatusPartialContent).Body().Equal(expectedBodyStr[0:7])
+
+	res := e.GET("/").WithHeader("Range", "bytes=0-6, 10-11").Expect().Status(http.StatusPartialContent)
+	mediaType, params, err := mime.ParseMediaType(res.Header("Content-Type").Raw())
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("expected a multipart/byteranges response but got %q: %v", mediaType, err)
+	}
+
+	r := multipart.NewReader(strings.NewReader(res.Body().Raw()), params["boundary"])
+	expected := []struct{ contentRange, body string }{
+		{fmt.Sprintf("bytes 0-6/%d", len(expectedBodyStr)), expectedBodyStr[0:7]},
+		{fmt.Sprintf("bytes 10-11/%d", len(expectedBodyStr)), expectedBodyStr[10:12]},
+	}
+	for _, exp := range expected {
+		part, err := r.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := part.Header.Get("Content-Range"); got != exp.contentRange {
+			t.Fatalf("expected Content-Range %q but got %q", exp.contentRange, got)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != exp.body {
+			t.Fatalf("expected part %q but got %q", exp.body, body)
+		}
+	}
+	if _, err = r.NextPart(); err != io.EOF {
+		t.Fatalf("expected 2 parts but got more: %v", err)
+	}
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddUint32(&n, 1) > 1 {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			res.Write([]byte("unavailable"))
+			return
+		}
+		res.Header().Set("Cache-Control", "stale-if-error=60")
+		res.Write([]byte(expectedBodyStr))
+	}), time.Second).MinimumLifetime(0)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	time.Sleep(time.Second + 100*time.Millisecond)
+	// the entry has been expired and the original handler fails, serve the stale one.
+	e.GET("/").Expect().Status(http.StatusOK).Header("Warning").Equal(`110 - "Response is Stale"`)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+}
+
+func TestCacheStaleRefreshPassThrough(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch atomic.AddUint32(&n, 1) {
+		case 1:
+			res.Header().Set("Cache-Control", "stale-if-error=60")
+			res.Write([]byte(expectedBodyStr))
+		case 2:
+			res.Write([]byte("data: 1\n\n"))
+			res.(http.Flusher).Flush()
+			res.Write([]byte("data: 2\n\n"))
+		default:
+			// bigger than the MaxBodySize.
+			res.Write([]byte(expectedBodyStr))
+			res.Write([]byte(expectedBodyStr))
+		}
+	}), time.Second).MinimumLifetime(0).MaxBodySize(len(expectedBodyStr))
+
+	serve := func() *stdhttptest.ResponseRecorder {
+		rec := stdhttptest.NewRecorder()
+		cachedHandler.ServeHTTP(rec, stdhttptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	serve()
+	time.Sleep(time.Second + 100*time.Millisecond)
+	// the refresh of the stale entry streams to the client.
+	if rec := serve(); !rec.Flushed || rec.Body.String() != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("expected the refreshed response to be flushed to the client but got flushed: %v, body: %q", rec.Flushed, rec.Body.String())
+	}
+	// the rest of an oversized body is passed through to the client.
+	if rec := serve(); rec.Body.String() != expectedBodyStr+expectedBodyStr {
+		t.Fatalf("expected the whole refreshed body but got %q", rec.Body.String())
+	}
+	// neither of them is stored, the stale entry is refreshed again.
+	serve()
+	if got := atomic.LoadUint32(&n); got != 4 {
+		t.Fatalf("expected the original handler to be executed 4 times but executed %d times", got)
+	}
+}
+
+func TestCacheGzip(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(res)
+		gw.Write([]byte(expectedBodyStr))
+		gw.Close()
+	}), 10*time.Second)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").WithHeader("Accept-Encoding", "gzip").Expect().Status(http.StatusOK).Header("Content-Encoding").Equal("gzip")
+	// the client doesn't accept gzip, the cached body is decompressed.
+	r := e.GET("/").Expect().Status(http.StatusOK)
+	r.Header("Content-Encoding").Empty()
+	r.Header("Content-Type").Equal("text/plain; charset=utf-8")
+	r.Body().Equal(expectedBodyStr)
+	e.GET("/").WithHeader("Accept-Encoding", "gzip;q=0").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").WithHeader("Accept-Encoding", "deflate, gzip").Expect().Status(http.StatusOK).
+		Header("Content-Encoding").Equal("gzip")
+
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the original handler to be executed once but executed %d times", got)
+	}
+}
+
+func TestCacheGzipContentType(t *testing.T) {
+	html := "<!DOCTYPE html><html><body>" + strings.Repeat(expectedBodyStr, 100) + "</body></html>"
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(res)
+		// many writes, the body is recorded in many chunks.
+		for i := 0; i < len(html); i += 256 {
+			end := i + 256
+			if end > len(html) {
+				end = len(html)
+			}
+			gw.Write([]byte(html[i:end]))
+		}
+		gw.Close()
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").WithHeader("Accept-Encoding", "gzip").Expect().Status(http.StatusOK)
+	// the content type is detected by the decompressed head of the body.
+	r := e.GET("/").Expect().Status(http.StatusOK)
+	r.Header("Content-Type").Equal("text/html; charset=utf-8")
+	r.Body().Equal(html)
+}
+
+func TestCacheFlush(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		flusher, ok := res.(http.Flusher)
+		if !ok {
+			t.Fatal("expected the response writer to be an http.Flusher")
+		}
+		res.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+		res.Write([]byte("data: 2\n\n"))
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 2; i++ {
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal("data: 1\n\ndata: 2\n\n")
+	}
+
+	// the streaming responses are not cached.
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the original handler to be executed 2 times but executed %d times", got)
+	}
+}
+
+// informationalWriter records the informational status codes which are sent before the final one.
+type informationalWriter struct {
+	*stdhttptest.ResponseRecorder
+	informational []int
+}
+
+func (w *informationalWriter) WriteHeader(statusCode int) {
+	if statusCode < http.StatusOK {
+		w.informational = append(w.informational, statusCode)
+		return
+	}
+	w.ResponseRecorder.WriteHeader(statusCode)
+}
+
+func TestCacheInformationalStatus(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		res.WriteHeader(http.StatusEarlyHints)
+		res.WriteHeader(http.StatusOK)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	w := &informationalWriter{ResponseRecorder: stdhttptest.NewRecorder()}
+	cachedHandler.ServeHTTP(w, stdhttptest.NewRequest(http.MethodGet, "/", nil))
+	if len(w.informational) != 1 || w.informational[0] != http.StatusEarlyHints {
+		t.Fatalf("expected the 103 status code to be sent to the client but got %v", w.informational)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != expectedBodyStr {
+		t.Fatalf("expected the final %d status code and the body but got %d: %q", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// the final response is cached, not the 103 one.
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the original handler to be executed once but executed %d times", got)
+	}
+}
+
+func TestCacheEmptyBody(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("X-Custom", "value")
+		if req.URL.Path == "/headers" {
+			// nor a status code neither a body.
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+	}), 10*time.Second)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 1; i <= 2; i++ {
+		// the empty responses are written to the client as they are, they are not cached.
+		r := e.GET("/").Expect().Status(http.StatusNoContent)
+		r.Header("X-Custom").Equal("value")
+		r.Body().Empty()
+	}
+	e.GET("/headers").Expect().Status(http.StatusOK).Header("X-Custom").Equal("value")
+
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		reqCtx.Response.Header.Set("X-Custom", "value")
+		reqCtx.SetStatusCode(fasthttp.StatusNoContent)
+	}, 10*time.Second)
+
+	fe := httptest.New(t, httptest.RequestHandler(fasthttpHandler.ServeHTTP))
+	r := fe.GET("/").Expect().Status(http.StatusNoContent)
+	r.Header("X-Custom").Equal("value")
+	r.Body().Empty()
+}
+
+func TestCacheRedirect(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/new" {
+			res.Write([]byte(expectedBodyStr))
+			return
+		}
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Location", "/new")
+		res.WriteHeader(http.StatusMovedPermanently)
+	}), 10*time.Second)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 2; i++ {
+		// the client follows the cached redirect too.
+		e.GET("/old").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the redirect to be executed once but executed %d times", got)
+	}
+}
+
+type testLogger struct {
+	lines []string
+	mu    sync.Mutex
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+	l.mu.Unlock()
+}
+
+func TestCacheLogger(t *testing.T) {
+	logger := new(testLogger)
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), 10*time.Second).Logger(logger)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.lines) != 3 || logger.lines[0] != "httpcache: miss GET/" ||
+		!strings.HasPrefix(logger.lines[1], "httpcache: stored GET/, expires at ") || logger.lines[2] != "httpcache: hit GET/" {
+		t.Fatalf("unexpected log lines: %q", logger.lines)
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		switch req.URL.Path {
+		case "/missing":
+			res.WriteHeader(http.StatusNotFound)
+		case "/teapot":
+			res.WriteHeader(http.StatusTeapot)
+		case "/private":
+			res.Header().Set("X-Visibility", "private")
+		}
+		res.Write([]byte(req.URL.Path))
+	}), cacheDuration, httpcache.Rules().Status(http.StatusOK, http.StatusNotFound).Method(http.MethodGet).
+		Header("X-Visibility", "^$").ClaimWhen(func(header ruleset.GetHeader) bool {
+		return header("Authorization") == ""
+	}))
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for _, path := range []string{"/", "/missing", "/teapot", "/private"} {
+		e.GET(path).Expect().Body().Equal(path)
+		e.GET(path).Expect().Body().Equal(path)
+	}
+	e.GET("/").WithHeader("Authorization", "Bearer x").Expect().Body().Equal("/")
+	// 2 cached, 2 not cached and 1 which bypassed the cache.
+	if got := atomic.LoadUint32(&n); got != 7 {
+		t.Fatalf("expected 7 executions of the original handler but got %d", got)
+	}
+
+	fasthttpRule := httpcache.Rules().Status(fasthttp.StatusOK).FasthttpRule()
+	for _, path := range []string{"/", "/missing"} {
+		reqCtx := new(fasthttp.RequestCtx)
+		reqCtx.Request.SetRequestURI(path)
+		if !fasthttpRule.Claim(reqCtx) {
+			t.Fatalf("%s: expected to be claimed", path)
+		}
+		if path == "/missing" {
+			reqCtx.SetStatusCode(fasthttp.StatusNotFound)
+		}
+		if valid := fasthttpRule.Valid(reqCtx); valid != (path == "/") {
+			t.Fatalf("%s: unexpected validation %v", path, valid)
+		}
+	}
+}
+
+func TestCacheValidWhenHeader(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		if req.URL.Path == "/cacheable" {
+			res.Header().Set("X-Cacheable", "true")
+		}
+		res.Write([]byte(expectedBodyStr))
+	}), 10*time.Second).AddRule(httpcache.ValidWhenHeader("X-Cacheable", "^true$"))
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 2; i++ {
+		e.GET("/cacheable").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+
+	// the "/" is not cached.
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+}
+
+func TestCacheIfSlowerThan(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		if req.URL.Path == "/slow" {
+			time.Sleep(60 * time.Millisecond)
+		}
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).CacheIfSlowerThan(50 * time.Millisecond)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 2; i++ {
+		e.GET("/slow").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/fast").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+
+	// only the "/slow" is cached.
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+}
+
+func TestCacheIfSlowerThanRefresh(t *testing.T) {
+	var n, fn uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddUint32(&n, 1) == 1 {
+			time.Sleep(60 * time.Millisecond)
+		}
+		res.Header().Set("Cache-Control", "stale-if-error=60")
+		res.Write([]byte(expectedBodyStr))
+	}), time.Second).MinimumLifetime(0).CacheIfSlowerThan(50 * time.Millisecond)
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		if atomic.AddUint32(&fn, 1) == 1 {
+			time.Sleep(60 * time.Millisecond)
+		}
+		reqCtx.Response.Header.Set("Cache-Control", "stale-if-error=60")
+		reqCtx.Write([]byte(expectedBodyStr))
+	}, time.Second).MinimumLifetime(0).CacheIfSlowerThan(50 * time.Millisecond)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	serveFasthttp := func() {
+		reqCtx := new(fasthttp.RequestCtx)
+		reqCtx.Request.SetRequestURI("/")
+		fasthttpHandler.ServeHTTP(reqCtx)
+		if body := string(reqCtx.Response.Body()); body != expectedBodyStr {
+			t.Fatalf("expected the fasthttp body %q but got %q", expectedBodyStr, body)
+		}
+	}
+
+	// the first, slow, response is cached.
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	serveFasthttp()
+	time.Sleep(time.Second + 100*time.Millisecond)
+	// the fast refreshes of the stale entry are not cached.
+	for i := 0; i < 2; i++ {
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		serveFasthttp()
+	}
+
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+	if got := atomic.LoadUint32(&fn); got != 3 {
+		t.Fatalf("expected the original fasthttp handler to be executed 3 times but executed %d times", got)
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), cacheDuration)
+
+	preview := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("preview") == "true" {
+			req = req.WithContext(httpcache.WithBypass(req.Context()))
+		}
+		cachedHandler.ServeHTTP(res, req)
+	})
+
+	e := httptest.New(t, httptest.Handler(preview))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	e.GET("/?preview=true").Expect().Status(http.StatusOK).Body().Equal("2")
+	e.GET("/?preview=true").Expect().Status(http.StatusOK).Body().Equal("3")
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+}
+
+func TestCacheExtend(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), time.Second).MinimumLifetime(0)
+
+	if cachedHandler.Extend("/", time.Minute) {
+		t.Fatal("expected no extension of a missing entry")
+	}
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	store := cachedHandler.GetStore()
+	stored := store.Get(store.Keys()[0])
+	expiresAt := stored.ExpiresAt()
+	if !cachedHandler.Extend("/", 2*time.Second) {
+		t.Fatal("expected the cached response to be extended")
+	}
+	// a copy of the entry is extended and saved, the served one is not modified.
+	if !stored.ExpiresAt().Equal(expiresAt) {
+		t.Fatal("expected the stored entry to be kept as it's")
+	}
+
+	time.Sleep(time.Second + 100*time.Millisecond)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+}
+
+func TestCacheSlidingExpiration(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), time.Second).MinimumLifetime(0).SlidingExpiration()
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	for i := 0; i < 3; i++ {
+		// each hit extends the expiration, the entry outlives its one second lifetime.
+		time.Sleep(600 * time.Millisecond)
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	}
+
+	time.Sleep(time.Second + 100*time.Millisecond)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("2")
+}
+
+// expiresIn returns the remaining lifetime of the only entry of the "store".
+func expiresIn(t *testing.T, store server.Store) time.Duration {
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 entry but got %d", len(keys))
+	}
+	return time.Until(store.Get(keys[0]).ExpiresAt())
+}
+
+func TestTTLPatterns(t *testing.T) {
+	patterns := uri.TTLPatterns{
+		"/static/*":            time.Hour,
+		"/static/css/*":        time.Minute,
+		"/static/css/main.css": time.Second,
+		"/a*":                  time.Hour,
+		"/a":                   time.Minute,
+	}
+
+	tests := []struct {
+		path    string
+		ttl     time.Duration
+		matched bool
+	}{
+		{"/static/img/logo.png", time.Hour, true},
+		// the longest pattern wins.
+		{"/static/css/site.css", time.Minute, true},
+		{"/static/css/main.css", time.Second, true},
+		// an exact pattern wins a wildcard one of the same length.
+		{"/a", time.Minute, true},
+		{"/about", time.Hour, true},
+		{"/other", 0, false},
+	}
+	for _, tt := range tests {
+		if ttl, ok := patterns.Match(tt.path); ttl != tt.ttl || ok != tt.matched {
+			t.Fatalf("%s: expected %s, %v but got %s, %v", tt.path, tt.ttl, tt.matched, ttl, ok)
+		}
+	}
+}
+
+func TestCacheTTLByPattern(t *testing.T) {
+	patterns := map[string]time.Duration{
+		"/static/*":     time.Hour,
+		"/static/css/*": 2 * time.Minute,
+	}
+	newHandlers := func() (*nethttp.Handler, *fhttp.Handler) {
+		// the lifetimes are taken by the headers, unless a pattern matches.
+		cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set("Cache-Control", "max-age=600")
+			if strings.HasSuffix(req.URL.Path, "/missing") {
+				res.WriteHeader(http.StatusNotFound)
+			}
+			res.Write([]byte(expectedBodyStr))
+		}), 0).MinimumLifetime(0).TTLByPattern(patterns).NotFoundTTL(10 * time.Second)
+		fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+			reqCtx.Response.Header.Set("Cache-Control", "max-age=600")
+			if strings.HasSuffix(string(reqCtx.Path()), "/missing") {
+				reqCtx.SetStatusCode(fasthttp.StatusNotFound)
+			}
+			reqCtx.Write([]byte(expectedBodyStr))
+		}, 0).MinimumLifetime(0).TTLByPattern(patterns).NotFoundTTL(10 * time.Second)
+		return cachedHandler, fasthttpHandler
+	}
+
+	tests := []struct {
+		path string
+		life time.Duration
+	}{
+		{"/static/img/logo.png", time.Hour},
+		// the longest pattern wins, it has priority over the max-age.
+		{"/static/css/site.css", 2 * time.Minute},
+		// the NotFoundTTL has priority over the patterns.
+		{"/static/missing", 10 * time.Second},
+		// the max-age is used for the paths that no pattern matches.
+		{"/other", 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		cachedHandler, fasthttpHandler := newHandlers()
+		httptest.New(t, httptest.Handler(cachedHandler)).GET(tt.path).Expect()
+		reqCtx := new(fasthttp.RequestCtx)
+		reqCtx.Request.SetRequestURI(tt.path)
+		fasthttpHandler.ServeHTTP(reqCtx)
+
+		for _, store := range []server.Store{cachedHandler.GetStore(), fasthttpHandler.GetStore()} {
+			if d := expiresIn(t, store); d > tt.life || d < tt.life-5*time.Second {
+				t.Fatalf("%s: expected the entry to expire in %s but it expires in %s", tt.path, tt.life, d)
+			}
+		}
+	}
+}
+
+func TestCacheNotFoundTTL(t *testing.T) {
+	var n, fn uint32
+	newHandlers := func(notFoundTTL time.Duration) (*nethttp.Handler, *fhttp.Handler) {
+		cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			atomic.AddUint32(&n, 1)
+			if req.URL.Path == "/missing" {
+				res.WriteHeader(http.StatusNotFound)
+			}
+			res.Write([]byte(expectedBodyStr))
+		}), cacheDuration).NotFoundTTL(notFoundTTL)
+		fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+			atomic.AddUint32(&fn, 1)
+			if string(reqCtx.Path()) == "/missing" {
+				reqCtx.SetStatusCode(fasthttp.StatusNotFound)
+			}
+			reqCtx.Write([]byte(expectedBodyStr))
+		}, cacheDuration).NotFoundTTL(notFoundTTL)
+		return cachedHandler, fasthttpHandler
+	}
+	serve := func(cachedHandler *nethttp.Handler, fasthttpHandler *fhttp.Handler, path string) {
+		httptest.New(t, httptest.Handler(cachedHandler)).GET(path).Expect().Body().Equal(expectedBodyStr)
+		reqCtx := new(fasthttp.RequestCtx)
+		reqCtx.Request.SetRequestURI(path)
+		fasthttpHandler.ServeHTTP(reqCtx)
+	}
+
+	// the 404 responses have their own lifetime.
+	cachedHandler, fasthttpHandler := newHandlers(time.Minute)
+	serve(cachedHandler, fasthttpHandler, "/missing")
+	for _, store := range []server.Store{cachedHandler.GetStore(), fasthttpHandler.GetStore()} {
+		if d := expiresIn(t, store); d > time.Minute || d < time.Minute-5*time.Second {
+			t.Fatalf("expected the 404 entry to expire in %s but it expires in %s", time.Minute, d)
+		}
+	}
+	// the rest of the responses keep the handler's one.
+	cachedHandler, fasthttpHandler = newHandlers(time.Minute)
+	serve(cachedHandler, fasthttpHandler, "/")
+	for _, store := range []server.Store{cachedHandler.GetStore(), fasthttpHandler.GetStore()} {
+		if d := expiresIn(t, store); d > cacheDuration || d < cacheDuration-time.Second {
+			t.Fatalf("expected the entry to expire in %s but it expires in %s", cacheDuration, d)
+		}
+	}
+
+	// a zero NotFoundTTL doesn't cache the 404 responses at all.
+	atomic.StoreUint32(&n, 0)
+	atomic.StoreUint32(&fn, 0)
+	cachedHandler, fasthttpHandler = newHandlers(0)
+	for i := 0; i < 2; i++ {
+		serve(cachedHandler, fasthttpHandler, "/missing")
+		serve(cachedHandler, fasthttpHandler, "/")
+	}
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+	if got := atomic.LoadUint32(&fn); got != 3 {
+		t.Fatalf("expected the original fasthttp handler to be executed 3 times but executed %d times", got)
+	}
+}
+
+func TestCacheSlidingExpirationLifetimes(t *testing.T) {
+	var n uint32
+	handler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		if req.URL.Path == "/missing" {
+			res.WriteHeader(http.StatusNotFound)
+		} else {
+			res.Header().Set("X-Cache-TTL", "120")
+		}
+		res.Write([]byte(expectedBodyStr))
+	})
+
+	// the touched response keeps its own lifetime, not the handler's one.
+	cachedHandler := httpcache.Cache(handler, 10*time.Second).MinimumLifetime(0).SlidingExpiration().TTLHeader("X-Cache-TTL")
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK)
+	e.GET("/").Expect().Status(http.StatusOK)
+	if d := expiresIn(t, cachedHandler.GetStore()); d < time.Minute {
+		t.Fatalf("expected the TTL header's lifetime after a hit but it expires in %s", d)
+	}
+
+	cachedHandler = httpcache.Cache(handler, 10*time.Second).MinimumLifetime(0).SlidingExpiration().NotFoundTTL(2 * time.Minute)
+	e = httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/missing").Expect().Status(http.StatusNotFound)
+	e.GET("/missing").Expect().Status(http.StatusNotFound)
+	if d := expiresIn(t, cachedHandler.GetStore()); d < time.Minute {
+		t.Fatalf("expected the 404 lifetime after a hit but it expires in %s", d)
+	}
+
+	// the lifetime of the handler is taken by the headers, a hit doesn't expire the entry.
+	atomic.StoreUint32(&n, 0)
+	cachedHandler = httpcache.Cache(handler, -1).MinimumLifetime(0).SlidingExpiration().TTLHeader("X-Cache-TTL")
+	e = httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 3; i++ {
+		e.GET("/").Expect().Status(http.StatusOK)
+	}
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the handler to be executed once but executed %d", got)
+	}
+}
+
+func TestStoreSwapEntry(t *testing.T) {
+	boltStore, err := server.NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.(io.Closer).Close()
+
+	for _, store := range []server.Store{server.NewMemoryStore(), server.NewSyncMapStore(0), server.NewMemoryStoreLFU(0, 0), boltStore} {
+		store.Set("GET/", http.StatusOK, "text/plain", []byte("1"), cacheDuration)
+		old := store.Get("GET/")
+		touched := old.Copy()
+		touched.Extend(time.Minute)
+		if !store.(server.Swapper).SwapEntry("GET/", old, touched) {
+			t.Fatalf("%T: expected the same entry to be swapped", store)
+		}
+		if d := time.Until(store.Get("GET/").ExpiresAt()); d < 30*time.Second {
+			t.Fatalf("%T: expected the swapped entry but it expires in %s", store, d)
+		}
+
+		// a newer response is stored meanwhile, it's not overwritten.
+		old = store.Get("GET/")
+		time.Sleep(time.Millisecond)
+		store.Set("GET/", http.StatusOK, "text/plain", []byte("2"), cacheDuration)
+		if store.(server.Swapper).SwapEntry("GET/", old, old.Copy()) {
+			t.Fatalf("%T: expected a replaced entry not to be swapped", store)
+		}
+		if res, ok := store.Get("GET/").Response(); !ok || string(res.Body()) != "2" {
+			t.Fatalf("%T: expected the newer response to be kept", store)
+		}
+	}
+}
+
+func TestCacheExpiresHeader(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/future":
+			res.Header().Set("Expires", time.Now().Add(2*time.Second).UTC().Format(http.TimeFormat))
+		case "/past":
+			res.Header().Set("Expires", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		case "/invalid":
+			res.Header().Set("Expires", "0")
+		}
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), -1).MinimumLifetime(0)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/future").Expect().Status(http.StatusOK).Body().Equal("1")
+	e.GET("/future").Expect().Status(http.StatusOK).Body().Equal("1")
+	// a past date is not cached.
+	e.GET("/past").Expect().Status(http.StatusOK).Body().Equal("2")
+	e.GET("/past").Expect().Status(http.StatusOK).Body().Equal("3")
+	// an invalid date is ignored, there is no lifetime.
+	e.GET("/invalid").Expect().Status(http.StatusOK).Body().Equal("4")
+	e.GET("/invalid").Expect().Status(http.StatusOK).Body().Equal("5")
+
+	time.Sleep(2*time.Second + 100*time.Millisecond)
+	e.GET("/future").Expect().Status(http.StatusOK).Body().Equal("6")
+
+	if d, ok := entry.ParseExpires("Wed, 21 Oct 2015 07:28:00 GMT"); !ok || d > 0 {
+		t.Fatalf("expected a past date but got %s, %v", d, ok)
+	}
+	if _, ok := entry.ParseExpires("-1"); ok {
+		t.Fatalf("expected an invalid date")
+	}
+}
+
+func TestCacheHitCacheControl(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("body"))
+	}), 10*time.Second).HitCacheControl(func(remaining time.Duration) string {
+		if remaining <= 0 || remaining > 10*time.Second {
+			return "invalid"
+		}
+		return "private, max-age=10"
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Header("Cache-Control").Empty()
+	e.GET("/").Expect().Status(http.StatusOK).Header("Cache-Control").Equal("private, max-age=10")
+}
+
+func TestCacheKeyByCookies(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second).KeyByCookies("bucket")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	e.GET("/").WithHeader("Cookie", "bucket=a").Expect().Status(http.StatusOK).Body().Equal("2")
+	e.GET("/").WithHeader("Cookie", "bucket=b; other=1").Expect().Status(http.StatusOK).Body().Equal("3")
+	e.GET("/").WithHeader("Cookie", "other=1; bucket=a").Expect().Status(http.StatusOK).Body().Equal("2")
+	// the requests without the cookie share the default key.
+	e.GET("/").WithHeader("Cookie", "other=2").Expect().Status(http.StatusOK).Body().Equal("1")
+}
+
+func TestCacheWarmURLs(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		switch req.URL.Path {
+		case "/fail":
+			res.WriteHeader(http.StatusInternalServerError)
+		case "/empty":
+		default:
+			res.Write([]byte("body of " + req.URL.Path))
+		}
+	}), cacheDuration)
+
+	errs := cachedHandler.WarmURLs("http://localhost", []string{"/a", "/b", "/c", "/empty", "/fail", "/%zz"}, 3)
+	if len(errs) != 2 || errs["/fail"] == nil || errs["/%zz"] == nil {
+		t.Fatalf("expected the errors of the failed and the invalid paths but got %v", errs)
+	}
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for _, path := range []string{"/a", "/b", "/c"} {
+		e.GET(path).Expect().Status(http.StatusOK).Body().Equal("body of " + path)
+	}
+	if got := atomic.LoadUint32(&n); got != 5 {
+		t.Fatalf("expected 5 executions of the original handler but got %d", got)
+	}
+
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		reqCtx.SetBodyString("body of " + string(reqCtx.Path()))
+	}, cacheDuration)
+	if errs := fasthttpHandler.WarmURLs("http://localhost", []string{"/a", "/b"}, 2); len(errs) != 0 {
+		t.Fatalf("expected no errors but got %v", errs)
+	}
+	if n := fasthttpHandler.GetStore().Len(); n != 2 {
+		t.Fatalf("expected 2 entries but got %d", n)
+	}
+}
+
+func TestCacheRefreshQueryParam(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second).RefreshQueryParam("__refresh", func(r *http.Request) bool {
+		return r.Header.Get("X-Internal") == "true"
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	// not trusted.
+	e.GET("/?__refresh=1").Expect().Status(http.StatusOK).Body().Equal("1")
+	// not a true value.
+	e.GET("/?__refresh=0").WithHeader("X-Internal", "true").Expect().Status(http.StatusOK).Body().Equal("1")
+	e.GET("/?__refresh=1").WithHeader("X-Internal", "true").Expect().Status(http.StatusOK).Body().Equal("2")
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("2")
+}
+
+func TestCacheRefreshKeepsStoredEntry(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second).RefreshQueryParam("__refresh", func(r *http.Request) bool {
+		return true
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+
+	store := cachedHandler.GetStore()
+	keys := store.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 entry but got %d", len(keys))
+	}
+	stored := store.Get(keys[0])
+	res, ok := stored.Response()
+	if !ok {
+		t.Fatalf("expected a valid stored response")
+	}
+
+	// the refreshed response is saved to a new entry, the served one is not modified.
+	e.GET("/?__refresh=1").Expect().Status(http.StatusOK).Body().Equal("2")
+	if got := string(res.Body()); got != "1" {
+		t.Fatalf("expected the previous response to be kept but got %q", got)
+	}
+	if store.Get(keys[0]) == stored {
+		t.Fatalf("expected the entry to be replaced")
+	}
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("2")
+}
+
+func TestCacheGetStore(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	// share the handler's store with the remote cache service.
+	s := server.NewHandler(cachedHandler.GetStore())
+	if s.Store() != cachedHandler.GetStore() {
+		t.Fatalf("expected the same store")
+	}
+	if n := s.Store().Len(); n != 1 {
+		t.Fatalf("expected 1 entry but got %d", n)
+	}
+}
+
+func TestCacheTTLHeader(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("X-Cache-TTL", req.URL.Query().Get("ttl"))
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second).MinimumLifetime(0).TTLHeader("X-Cache-TTL")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/?ttl=1").Expect().Status(http.StatusOK).Header("X-Cache-TTL").Empty()
+	e.GET("/?ttl=1").Expect().Status(http.StatusOK).Body().Equal("1")
+	// an invalid value falls back to the handler's expiration.
+	e.GET("/?ttl=invalid").Expect().Status(http.StatusOK).Header("X-Cache-TTL").Empty()
+
+	time.Sleep(time.Second + 100*time.Millisecond)
+	e.GET("/?ttl=1").Expect().Status(http.StatusOK).Body().Equal("3")
+	e.GET("/?ttl=invalid").Expect().Status(http.StatusOK).Body().Equal("2")
+}
+
+func TestBoltStore(t *testing.T) {
+	for _, codec := range []entry.Codec{entry.GobCodec{}, entry.JSONCodec{}} {
+		path := filepath.Join(t.TempDir(), "cache.db")
+		store, err := server.NewBoltStoreWithCodec(path, 0, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := entry.NewEntryMinimum(cacheDuration, 0)
+		e.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+		e.Revalidate(`"v1"`, "Mon, 02 Jan 2006 15:04:05 GMT")
+		e.SetHeader(map[string][]string{"Set-Cookie": {"a=1", "b=2"}})
+		e.SetContentEncoding("gzip")
+		store.(server.EntrySetter).SetEntry("GET/", e)
+		store.Set("GET/set", http.StatusNotFound, "text/html", []byte("not found"), cacheDuration)
+		store.(io.Closer).Close()
+
+		// the entries are kept after a reopen.
+		store, err = server.NewBoltStoreWithCodec(path, 0, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := store.Len(); n != 2 {
+			t.Fatalf("expected 2 entries after the reopen but got %d", n)
+		}
+
+		got := store.Get("GET/")
+		if got == nil {
+			t.Fatalf("expected the entry to be persisted")
+		}
+		res, ok := got.Response()
+		if !ok {
+			t.Fatalf("expected a valid response")
+		}
+		if !got.ExpiresAt().Equal(e.ExpiresAt()) || got.LifeTime() != e.LifeTime() {
+			t.Fatalf("expected the expiration %s but got %s", e.ExpiresAt(), got.ExpiresAt())
+		}
+		if res.StatusCode() != http.StatusOK || res.ContentType() != "text/plain" || string(res.Body()) != expectedBodyStr {
+			t.Fatalf("unexpected response %d %q %q", res.StatusCode(), res.ContentType(), res.Body())
+		}
+		if !res.Revalidate() || res.ETag() != `"v1"` || res.LastModified() != "Mon, 02 Jan 2006 15:04:05 GMT" {
+			t.Fatalf("expected the validators to be kept but got %q %q", res.ETag(), res.LastModified())
+		}
+		if cookies := res.Header()["Set-Cookie"]; len(cookies) != 2 || cookies[0] != "a=1" || cookies[1] != "b=2" {
+			t.Fatalf("expected the headers to be kept but got %v", res.Header())
+		}
+		if res.ContentEncoding() != "gzip" {
+			t.Fatalf("expected the content encoding to be kept but got %q", res.ContentEncoding())
+		}
+
+		if res, ok := store.Get("GET/set").Response(); !ok || res.StatusCode() != http.StatusNotFound || string(res.Body()) != "not found" {
+			t.Fatalf("expected the set response to be persisted")
+		}
+		store.(io.Closer).Close()
+	}
+}
+
+func TestBoltStoreExpiration(t *testing.T) {
+	store, err := server.NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.(io.Closer).Close()
+
+	// the Set honors the minimum cache duration, set a shorter one.
+	expired := entry.NewEntryMinimum(10*time.Millisecond, 0)
+	expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+	store.(server.EntrySetter).SetEntry("GET/expired", expired)
+	store.Set("GET/valid", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+	time.Sleep(20 * time.Millisecond)
+
+	// the expired entry is still there, but its response is not served.
+	if _, ok := store.Get("GET/expired").Response(); ok {
+		t.Fatalf("expected the expired response to be invalid")
+	}
+	if n := server.ClearExpired(store); n != 1 {
+		t.Fatalf("expected one expired entry to be removed but removed %d", n)
+	}
+	if keys := store.Keys(); len(keys) != 1 || keys[0] != "GET/valid" {
+		t.Fatalf("expected only the valid entry to be kept but got %v", keys)
+	}
+
+	// the background scan removes them too.
+	gcStore, err := server.NewBoltStore(filepath.Join(t.TempDir(), "gc.db"), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gcStore.(io.Closer).Close()
+
+	expired = entry.NewEntryMinimum(10*time.Millisecond, 0)
+	expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+	gcStore.(server.EntrySetter).SetEntry("GET/expired", expired)
+	gcStore.Set("GET/valid", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+	time.Sleep(200 * time.Millisecond)
+	if keys := gcStore.Keys(); len(keys) != 1 || keys[0] != "GET/valid" {
+		t.Fatalf("expected the expired entry to be removed by the scan but got %v", keys)
+	}
+}
+
+func TestCacheOnStoreError(t *testing.T) {
+	// a closed database fails all of its writes.
+	boltStore, err := server.NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boltStore.(io.Closer).Close()
+
+	logger := new(testLogger)
+	cachedHandler := httpcache.CacheWithStore(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration, boltStore).Logger(logger)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	logger.mu.Lock()
+	if len(logger.lines) != 2 || !strings.HasPrefix(logger.lines[1], "httpcache: store GET/: ") {
+		t.Fatalf("expected the store's error to be logged but got %v", logger.lines)
+	}
+	logger.mu.Unlock()
+
+	// nothing listens there.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := remotescheme + ln.Addr().String()
+	ln.Close()
+
+	var n uint32
+	bodyHandler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	})
+
+	e = httptest.New(t, httptest.Handler(httpcache.CacheRemote(bodyHandler, cacheDuration, remote)))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	clientHandler := httpcache.CacheRemote(bodyHandler, cacheDuration, remote).OnStoreError(cfg.FailClosed).Cooldown(time.Minute)
+	e = httptest.New(t, httptest.Handler(clientHandler))
+	e.GET("/").Expect().Status(http.StatusServiceUnavailable)
+	// in its cooldown.
+	e.GET("/").Expect().Status(http.StatusServiceUnavailable)
+
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected one execution of the original handler but got %d", got)
+	}
+}
+
+func TestCacheRemoteTimeout(t *testing.T) {
+	// a remote cache server which hangs.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+
+	clientHandler := httpcache.CacheRemote(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration, remotescheme+ln.Addr().String()).RemoteClient(&http.Client{}).RemoteTimeout(100 * time.Millisecond)
+
+	e := httptest.New(t, httptest.Handler(clientHandler))
+	start := time.Now()
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	// the remote GET and POST requests time out.
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected the remote requests to time out but the request took %s", elapsed)
+	}
+}
+
+func TestCacheRemoteFailOpen(t *testing.T) {
+	// a remote cache server which drops its connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var gets, posts uint32
+	go http.Serve(ln, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			atomic.AddUint32(&posts, 1)
+		} else {
+			atomic.AddUint32(&gets, 1)
+		}
+		if conn, _, err := res.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+
+	clientHandler := httpcache.CacheRemote(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration, remotescheme+ln.Addr().String()).RemoteClient(&http.Client{}).Retries(1, 10*time.Millisecond)
+
+	e := httptest.New(t, httptest.Handler(clientHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if atomic.LoadUint32(&gets) == 0 {
+		t.Fatalf("expected the remote get to be sent")
+	}
+	// the remote get failed, the response is not posted.
+	if n := atomic.LoadUint32(&posts); n != 0 {
+		t.Fatalf("expected no remote post after a failed get but got %d", n)
+	}
+
+	// the retries stop when the client goes away.
+	clientHandler = httpcache.CacheRemote(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration, remotescheme+ln.Addr().String()).RemoteClient(&http.Client{}).Retries(3, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	clientHandler.ServeHTTP(stdhttptest.NewRecorder(), stdhttptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected the retries to stop with the request's context but the request took %s", elapsed)
+	}
+}
+
+// unreadBody is a request body which fails the test if it's read.
+type unreadBody struct {
+	t *testing.T
+}
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Fatal("expected the request's body not to be read on a cache hit")
+	return 0, io.EOF
+}
+
+func (b unreadBody) Close() error { return nil }
+
+func TestCacheHitRequestBody(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).IdempotencyKey("Idempotency-Key")
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		r := stdhttptest.NewRequest(method, "/", strings.NewReader("the first body is read by the original handler"))
+		r.Header.Set("Idempotency-Key", "1")
+		cachedHandler.ServeHTTP(stdhttptest.NewRecorder(), r)
+
+		r = stdhttptest.NewRequest(method, "/", nil)
+		r.Header.Set("Idempotency-Key", "1")
+		r.Body = unreadBody{t}
+		w := stdhttptest.NewRecorder()
+		cachedHandler.ServeHTTP(w, r)
+		if got := w.Body.String(); got != expectedBodyStr {
+			t.Fatalf("expected the cached body %q but got %q", expectedBodyStr, got)
+		}
+	}
+}
+
+func TestCacheNopStore(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.CacheWithStore(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second, server.NewNopStore())
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 1; i <= 3; i++ {
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(strconv.Itoa(i))
+	}
+}
+
+func TestCacheKeyPrefixHeader(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second).KeyPrefixHeader("X-Tenant-ID", "public")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").WithHeader("X-Tenant-ID", "a").Expect().Status(http.StatusOK).Body().Equal("1")
+	e.GET("/").WithHeader("X-Tenant-ID", "b").Expect().Status(http.StatusOK).Body().Equal("2")
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("3")
+	e.GET("/").WithHeader("X-Tenant-ID", "a").Expect().Status(http.StatusOK).Body().Equal("1")
+	// the requests without the header use the fallback's prefix.
+	e.GET("/").WithHeader("X-Tenant-ID", "public").Expect().Status(http.StatusOK).Body().Equal("3")
+
+	cachedHandler.Invalidate("a|/")
+	e.GET("/").WithHeader("X-Tenant-ID", "a").Expect().Status(http.StatusOK).Body().Equal("4")
+	e.GET("/").WithHeader("X-Tenant-ID", "b").Expect().Status(http.StatusOK).Body().Equal("2")
+}
+
+func TestCacheVaryAccept(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		if strings.Contains(req.Header.Get("Accept"), "json") {
+			res.Write([]byte(`{"body":"json"}`))
+			return
+		}
+		res.Write([]byte("<body>xml</body>"))
+	}), cacheDuration).VaryAccept()
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").WithHeader("Accept", "application/json").Expect().Status(http.StatusOK).Body().Equal(`{"body":"json"}`)
+	e.GET("/").WithHeader("Accept", "text/xml").Expect().Status(http.StatusOK).Body().Equal("<body>xml</body>")
+	// the equivalent ones share the cache key.
+	e.GET("/").WithHeader("Accept", "text/xml;q=0.5, Application/JSON; charset=utf-8").Expect().
+		Status(http.StatusOK).Body().Equal(`{"body":"json"}`)
+	e.GET("/").WithHeader("Accept", "text/xml, application/json;q=0.9").Expect().
+		Status(http.StatusOK).Body().Equal("<body>xml</body>")
+
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the original handler to be executed 2 times but executed %d times", got)
+	}
+}
+
+func TestCacheSetEnabled(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), 10*time.Second)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+
+	cachedHandler.SetEnabled(false)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("2")
+
+	cachedHandler.SetEnabled(true)
+	httpcache.SetEnabled(false)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("3")
+
+	httpcache.SetEnabled(true)
+	// the entry is kept while the caching is disabled.
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+}
+
+func TestCacheMustRevalidate(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddUint32(&n, 1) > 1 {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			res.Write([]byte("unavailable"))
+			return
+		}
+		res.Header().Set("Cache-Control", "stale-if-error=60, must-revalidate")
+		res.Write([]byte(expectedBodyStr))
+	}), time.Second).MinimumLifetime(0)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	time.Sleep(time.Second + 100*time.Millisecond)
+	// the entry has been expired and it must be revalidated, the stale one is never served.
+	e.GET("/").Expect().Status(http.StatusServiceUnavailable).Body().Equal("unavailable")
+}
+
+func TestCachePanic(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddUint32(&n, 1) == 1 {
+			panic("origin failed")
+		}
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).OnPanic(func(res http.ResponseWriter, req *http.Request, err interface{}) {
+		res.WriteHeader(http.StatusInternalServerError)
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	// the panicked response is not cached.
+	e.GET("/").Expect().Status(http.StatusInternalServerError)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 2 {
+		t.Fatal(errTestFailed.Format(2, counter))
+	}
+}
+
+func TestCacheHead(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.CacheFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}, cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	// served by the cached GET response.
+	e.HEAD("/").Expect().Status(http.StatusOK).Header("Content-Length").Equal(strconv.Itoa(len(expectedBodyStr)))
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 1 {
+		t.Fatal(errTestFailed.Format(1, counter))
+	}
+}
+
+func TestCacheInvalidateOn(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	update := cachedHandler.InvalidateOn([]string{http.MethodPost}, func(req *http.Request) []string {
+		return []string{req.URL.Path}
+	})(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			update.ServeHTTP(res, req)
+			return
+		}
+		cachedHandler.ServeHTTP(res, req)
+	})
+
+	e := httptest.New(t, httptest.Handler(mux))
+	e.GET("/articles").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/articles").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.POST("/articles").Expect().Status(http.StatusNoContent)
+	// invalidated by the POST.
+	e.GET("/articles").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 2 {
+		t.Fatal(errTestFailed.Format(2, counter))
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(strconv.Itoa(int(atomic.AddUint32(&n, 1)))))
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("1")
+	cachedHandler.Clear()
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("2")
+}
+
+func TestCacheClearWhileMiss(t *testing.T) {
+	const misses = 50
+	store := server.NewMemoryStore()
+	release := make(chan struct{})
+
+	var started sync.WaitGroup
+	started.Add(misses)
+	cachedHandler := httpcache.CacheWithStore(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		started.Done()
+		<-release
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration, store)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	var done sync.WaitGroup
+	for i := 0; i < misses; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			e.GET("/" + strconv.Itoa(i)).Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		}(i)
+	}
+
+	// the misses are in progress, they should not re-insert their entries after the clear.
+	started.Wait()
+	store.(server.Clearer).Clear()
+	close(release)
+	done.Wait()
+
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Fatalf("expected an empty store after the clear but it has %d entries", len(keys))
+	}
+}
+
+func TestClearExpired(t *testing.T) {
+	boltStore, err := server.NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.(io.Closer).Close()
+
+	for _, store := range []server.Store{server.NewMemoryStore(), server.NewSyncMapStore(0), server.NewMemoryStoreLFU(0, 0), boltStore} {
+		setter := store.(server.EntrySetter)
+		expired := entry.NewEntryMinimum(10*time.Millisecond, 0)
+		expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+		setter.SetEntry("GET/expired", expired)
+		// expired too but it can still be served as stale.
+		stale := entry.NewEntryMinimum(10*time.Millisecond, 0)
+		stale.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+		stale.StaleIfError(time.Minute)
+		setter.SetEntry("GET/stale", stale)
+		store.Set("GET/valid", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+
+		time.Sleep(20 * time.Millisecond)
+		if n := server.ClearExpired(store); n != 1 {
+			t.Fatalf("%T: expected one expired entry to be removed but removed %d", store, n)
+		}
+		keys := store.Keys()
+		sort.Strings(keys)
+		if len(keys) != 2 || keys[0] != "GET/stale" || keys[1] != "GET/valid" {
+			t.Fatalf("%T: expected the stale and the valid entries to be kept but got %v", store, keys)
+		}
+	}
+}
+
+func TestCacheDefaultStoreGC(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), time.Second).MinimumLifetime(0)
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		reqCtx.Write([]byte(expectedBodyStr))
+	}, time.Second).MinimumLifetime(0)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 3; i++ {
+		// each query is a different key, which is never requested again.
+		e.GET("/").WithQuery("page", i).Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		reqCtx := new(fasthttp.RequestCtx)
+		reqCtx.Request.SetRequestURI("/?page=" + strconv.Itoa(i))
+		fasthttpHandler.ServeHTTP(reqCtx)
+	}
+
+	if n := cachedHandler.GetStore().Len(); n != 3 {
+		t.Fatalf("expected 3 entries but got %d", n)
+	}
+	if n := fasthttpHandler.GetStore().Len(); n != 3 {
+		t.Fatalf("expected 3 fasthttp entries but got %d", n)
+	}
+
+	// the default store is scanned every expiration duration, since its first stored response.
+	time.Sleep(2*time.Second + 500*time.Millisecond)
+	if n := cachedHandler.GetStore().Len(); n != 0 {
+		t.Fatalf("expected the expired entries to be removed but got %d", n)
+	}
+	if n := fasthttpHandler.GetStore().Len(); n != 0 {
+		t.Fatalf("expected the expired fasthttp entries to be removed but got %d", n)
+	}
+
+	// the Close stops the scan, the entries are kept.
+	cachedHandler.Close()
+	fasthttpHandler.Close()
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	reqCtx := new(fasthttp.RequestCtx)
+	reqCtx.Request.SetRequestURI("/")
+	fasthttpHandler.ServeHTTP(reqCtx)
+	time.Sleep(time.Second + 500*time.Millisecond)
+	if n := cachedHandler.GetStore().Len(); n != 1 {
+		t.Fatalf("expected the expired entry to be kept after the Close but got %d entries", n)
+	}
+	if n := fasthttpHandler.GetStore().Len(); n != 1 {
+		t.Fatalf("expected the expired fasthttp entry to be kept after the Close but got %d entries", n)
+	}
+}
+
+func TestCacheIdempotencyKey(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).IdempotencyKey("Idempotency-Key")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.POST("/payments").WithHeader("Idempotency-Key", "1").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	// a retry, replayed.
+	e.POST("/payments").WithHeader("Idempotency-Key", "1").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.POST("/payments").WithHeader("Idempotency-Key", "2").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	// no idempotency key, not cached.
+	e.POST("/payments").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.POST("/payments").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 4 {
+		t.Fatal(errTestFailed.Format(4, counter))
+	}
+}
+
+func TestCacheCookies(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		http.SetCookie(res, &http.Cookie{Name: "theme", Value: "dark"})
+		http.SetCookie(res, &http.Cookie{Name: "lang", Value: "en"})
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).CacheCookies().CacheStatusHeader("")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	res := e.GET("/").Expect().Status(http.StatusOK)
+	res.Header("X-Cache").Equal("HIT")
+	// each cookie is replayed as a separate header.
+	if cookies := res.Raw().Header["Set-Cookie"]; len(cookies) != 2 || cookies[0] != "theme=dark" || cookies[1] != "lang=en" {
+		t.Fatalf("expected the two cookies to be replayed but got %v", cookies)
+	}
+}
+
+func TestCacheSetCookie(t *testing.T) {
+	var n uint32
+	setCookie := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		http.SetCookie(res, &http.Cookie{Name: "session", Value: "secret"})
+		res.Write([]byte(expectedBodyStr))
+	})
+
+	// the responses which set cookies are not cached by default.
+	e := httptest.New(t, httptest.Handler(httpcache.Cache(setCookie, cacheDuration)))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the handler to be executed 2 times but executed %d", got)
+	}
+
+	atomic.StoreUint32(&n, 0)
+	e = httptest.New(t, httptest.Handler(httpcache.Cache(setCookie, cacheDuration).AllowSetCookie()))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	// the cookie is not replayed without the CacheCookies.
+	e.GET("/").Expect().Status(http.StatusOK).Header("Set-Cookie").Empty()
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the handler to be executed once but executed %d", got)
+	}
+}
+
+func TestRemotePurge(t *testing.T) {
+	handler := server.NewHandlerWithConfig(nil, server.Config{PurgeToken: "secret"})
+	handler.Preload("GEThttp:///a", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+	handler.Preload("GEThttp:///b/1", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+	handler.Preload("GEThttp:///b/2", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(handler))
+	e.Request("PURGE", "/").Expect().Status(http.StatusUnauthorized)
+	e.Request("PURGE", "/?cache_prefix=GEThttp:///b/").WithHeader("Authorization", "Bearer secret").
+		Expect().Status(http.StatusOK).Body().Equal("2")
+	e.Request("PURGE", "/").WithHeader("Authorization", "Bearer secret").
+		Expect().Status(http.StatusOK).Body().Equal("1")
+}
+
+func TestRemoteLimits(t *testing.T) {
+	body := []byte(expectedBodyStr)
+	post := func(e *httpexpect.Expect, key string, body []byte) *httpexpect.Response {
+		return e.POST("/").WithQuery(cfg.QueryCacheKey, key).WithQuery(cfg.QueryCacheDuration, 60).
+			WithBytes(body).Expect()
+	}
+
+	// MaxEntries, a replaced entry doesn't count twice, a deleted one makes room.
+	handler := server.NewHandlerWithConfig(nil, server.Config{MaxEntries: 2})
+	e := httptest.New(t, httptest.Handler(handler))
+	post(e, "GEThttp:///a", body).Status(cfg.SuccessStatus)
+	post(e, "GEThttp:///b", body).Status(cfg.SuccessStatus)
+	post(e, "GEThttp:///c", body).Status(cfg.FailStatus)
+	post(e, "GEThttp:///a", body).Status(cfg.SuccessStatus)
+	e.DELETE("/").WithQuery(cfg.QueryCacheKey, "GEThttp:///a").Expect().Status(cfg.SuccessStatus)
+	post(e, "GEThttp:///c", body).Status(cfg.SuccessStatus)
+
+	// MaxBytes, a purge makes room.
+	handler = server.NewHandlerWithConfig(nil, server.Config{MaxBytes: int64(2 * len(body)), PurgeToken: "secret"})
+	e = httptest.New(t, httptest.Handler(handler))
+	post(e, "GEThttp:///a", body).Status(cfg.SuccessStatus)
+	post(e, "GEThttp:///b", body).Status(cfg.SuccessStatus)
+	post(e, "GEThttp:///c", body[:1]).Status(cfg.FailStatus)
+	// a smaller body of an existing entry fits.
+	post(e, "GEThttp:///b", body[:1]).Status(cfg.SuccessStatus)
+	post(e, "GEThttp:///c", body).Status(cfg.FailStatus)
+	e.Request("PURGE", "/").WithQuery(cfg.QueryCachePrefix, "GEThttp:///a").WithHeader("Authorization", "Bearer secret").
+		Expect().Status(http.StatusOK).Body().Equal("1")
+	post(e, "GEThttp:///c", body).Status(cfg.SuccessStatus)
+
+	// MaxBodySize.
+	handler = server.NewHandlerWithConfig(nil, server.Config{MaxBodySize: len(body)})
+	e = httptest.New(t, httptest.Handler(handler))
+	post(e, "GEThttp:///a", append(body, '!')).Status(cfg.FailStatus)
+	post(e, "GEThttp:///a", body).Status(cfg.SuccessStatus)
+	if handler.Preload("GEThttp:///b", http.StatusOK, "text/plain", append(body, '!'), cacheDuration) {
+		t.Fatal("expected a too big preloaded body to be rejected")
+	}
+
+	// the expired entries are removed to make room.
+	handler = server.NewHandlerWithConfig(nil, server.Config{MaxEntries: 1, MinimumDuration: time.Millisecond})
+	e = httptest.New(t, httptest.Handler(handler))
+	if !handler.Preload("GEThttp:///a", http.StatusOK, "text/plain", body, 10*time.Millisecond) {
+		t.Fatal("expected the entry to be preloaded")
+	}
+	post(e, "GEThttp:///b", body).Status(cfg.FailStatus)
+	time.Sleep(20 * time.Millisecond)
+	post(e, "GEThttp:///b", body).Status(cfg.SuccessStatus)
+	if keys := handler.Store().Keys(); len(keys) != 1 || keys[0] != "GEThttp:///b" {
+		t.Fatalf("expected the expired entry to be replaced but got %v", keys)
+	}
+}
+
+func TestRemoteMinimumDuration(t *testing.T) {
+	tests := []struct {
+		minimum  time.Duration
+		seconds  int
+		expected time.Duration
+	}{
+		// the DefaultMinimumDuration honors the durations below the cfg.MinimumCacheDuration.
+		{0, 1, time.Second},
+		{10 * time.Second, 3, 10 * time.Second},
+		{10 * time.Second, 10, 10 * time.Second},
+		{10 * time.Second, 20, 20 * time.Second},
+	}
+
+	for i, tt := range tests {
+		handler := server.NewHandlerWithConfig(nil, server.Config{MinimumDuration: tt.minimum})
+		e := httptest.New(t, httptest.Handler(handler))
+		e.POST("/").WithQuery(cfg.QueryCacheKey, "GEThttp:///a").WithQuery(cfg.QueryCacheDuration, tt.seconds).
+			WithBytes([]byte(expectedBodyStr)).Expect().Status(cfg.SuccessStatus)
+		if got := expiresIn(t, handler.Store()); got <= tt.expected-time.Second || got > tt.expected {
+			t.Fatalf("[%d] expected the entry to expire in %s but got %s", i, tt.expected, got)
+		}
+	}
+
+	// the preloaded entries are bounded by the same minimum.
+	handler := server.NewHandlerWithConfig(nil, server.Config{MinimumDuration: 10 * time.Second})
+	handler.Preload("GEThttp:///a", http.StatusOK, "text/plain", []byte(expectedBodyStr), 3*time.Second)
+	if got := expiresIn(t, handler.Store()); got <= 9*time.Second || got > 10*time.Second {
+		t.Fatalf("expected the preloaded entry to expire in 10s but got %s", got)
+	}
+}
+
+func TestRemoteDebugHandler(t *testing.T) {
+	handler := server.NewHandlerWithConfig(nil, server.Config{DebugToken: "secret"})
+	handler.Preload("GEThttp:///b", http.StatusOK, "text/html", []byte("<html></html>"), time.Minute)
+	handler.Preload("GEThttp:///a", http.StatusCreated, "text/plain", []byte(expectedBodyStr), time.Minute)
+	expired := entry.NewEntryMinimum(10*time.Millisecond, 0)
+	expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+	handler.Store().(server.EntrySetter).SetEntry("GEThttp:///expired", expired)
+	time.Sleep(20 * time.Millisecond)
+
+	serve := func(authorization string) *stdhttptest.ResponseRecorder {
+		req := stdhttptest.NewRequest(http.MethodGet, "/debug", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := stdhttptest.NewRecorder()
+		handler.DebugHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "secret"} {
+		if rec := serve(authorization); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected %d but got %d", authorization, http.StatusUnauthorized, rec.Code)
+		}
+	}
+
+	rec := serve("Bearer secret")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("expected a JSON response but got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var entries []struct {
+		Key         string  `json:"key"`
+		StatusCode  int     `json:"statusCode"`
+		ContentType string  `json:"contentType"`
+		Size        int     `json:"size"`
+		Age         float64 `json:"age"`
+		TTL         float64 `json:"ttl"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	// the valid entries only, sorted by their key.
+	if len(entries) != 2 || entries[0].Key != "GEThttp:///a" || entries[1].Key != "GEThttp:///b" {
+		t.Fatalf("expected the 2 valid entries but got %+v", entries)
+	}
+	a := entries[0]
+	if a.StatusCode != http.StatusCreated || a.ContentType != "text/plain" || a.Size != len(expectedBodyStr) {
+		t.Fatalf("unexpected entry %+v", a)
+	}
+	if a.TTL <= 55 || a.TTL > 60 || a.Age < 0 || a.Age > 5 {
+		t.Fatalf("unexpected age %v and ttl %v", a.Age, a.TTL)
+	}
+}
+
+func TestInvalidateRemote(t *testing.T) {
+	store := server.NewMemoryStore()
+	srv := stdhttptest.NewServer(server.NewHandler(store))
+	defer srv.Close()
+	failing := stdhttptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(cfg.FailStatus)
+	}))
+	defer failing.Close()
+
+	store.Set("GEThttp:///a?x=1", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+	store.Set("GEThttp:///b", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+
+	req := stdhttptest.NewRequest(http.MethodGet, "/a?x=1", nil)
+	if err := httpcache.InvalidateRemote(srv.URL, req); err != nil {
+		t.Fatal(err)
+	}
+	if store.Get("GEThttp:///a?x=1") != nil {
+		t.Fatal("expected the remote entry to be invalidated")
+	}
+	if err := httpcache.InvalidateRemote(failing.URL, req); err != nethttp.ErrInvalidateFailed {
+		t.Fatalf("expected the ErrInvalidateFailed but got %v", err)
+	}
+
+	reqCtx := new(fasthttp.RequestCtx)
+	reqCtx.Request.SetRequestURI("/b")
+	if err := httpcache.InvalidateRemoteFasthttp(srv.URL, reqCtx); err != nil {
+		t.Fatal(err)
+	}
+	if store.Get("GEThttp:///b") != nil {
+		t.Fatal("expected the remote entry to be invalidated by the fasthttp request")
+	}
+	if err := httpcache.InvalidateRemoteFasthttp(failing.URL, reqCtx); err != fhttp.ErrInvalidateFailed {
+		t.Fatalf("expected the fasthttp ErrInvalidateFailed but got %v", err)
+	}
+
+	// the error of an unreachable server is returned as it is.
+	addr := failing.URL
+	failing.Close()
+	if err := httpcache.InvalidateRemote(addr, req); err == nil || err == nethttp.ErrInvalidateFailed {
+		t.Fatalf("expected the connection error but got %v", err)
+	}
+	if err := httpcache.InvalidateRemoteFasthttp(addr, reqCtx); err == nil || err == fhttp.ErrInvalidateFailed {
+		t.Fatalf("expected the fasthttp connection error but got %v", err)
+	}
+}
+
+func TestRemotePreloadHandler(t *testing.T) {
+	handler := server.NewHandler(nil)
+	ok := handler.PreloadHandler(http.MethodGet, "/a?page=1", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusCreated)
+		res.Write([]byte("<html>" + req.URL.Query().Get("page") + "</html>"))
+	}), cacheDuration)
+	if !ok {
+		t.Fatalf("expected the response to be preloaded")
+	}
+	if handler.PreloadHandler(http.MethodGet, "/empty", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), cacheDuration) {
+		t.Fatalf("expected an empty response not to be preloaded")
+	}
+
+	e := handler.Store().Get("GEThttp:///a?page=1")
+	if e == nil {
+		t.Fatalf("expected the preloaded entry under the client's key")
+	}
+	res, _ := e.Response()
+	if res.StatusCode() != http.StatusCreated || string(res.Body()) != "<html>1</html>" ||
+		!strings.HasPrefix(res.ContentType(), "text/html") {
+		t.Fatalf("unexpected preloaded response %d %q %q", res.StatusCode(), res.ContentType(), res.Body())
+	}
+}
+
+func TestRing(t *testing.T) {
+	addrs := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080"}
+	ring := uri.NewRing(addrs, 0)
+	smaller := uri.NewRing(addrs[:2], 0)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "GEThttp:///articles/" + strconv.Itoa(i)
+		addr := ring.Get(key)
+		counts[addr]++
+		// only the keys of the removed address move.
+		if addr != addrs[2] && smaller.Get(key) != addr {
+			t.Fatalf("%s: expected to stay at %s but moved to %s", key, addr, smaller.Get(key))
+		}
+	}
+
+	for _, addr := range addrs {
+		if counts[addr] < 500 {
+			t.Fatalf("%s: expected an even distribution but got %v", addr, counts)
+		}
+	}
+
+	if addr := uri.NewRing(nil, 0).Get("GEThttp:///"); addr != "" {
+		t.Fatalf("expected no address but got %s", addr)
+	}
+}
+
+func TestRingOrder(t *testing.T) {
+	var addrs []string
+	for i := 1; i <= 20; i++ {
+		addrs = append(addrs, "http://10.0.0."+strconv.Itoa(i)+":8080")
+	}
+	reversed := make([]string, 0, len(addrs)+1)
+	for i := len(addrs) - 1; i >= 0; i-- {
+		reversed = append(reversed, addrs[i])
+	}
+	// a duplicated one too.
+	reversed = append(reversed, addrs[0])
+
+	ring, other := uri.NewRing(addrs, 0), uri.NewRing(reversed, 0)
+	for i := 0; i < 3000; i++ {
+		key := "GEThttp:///articles/" + strconv.Itoa(i)
+		if a, b := ring.Get(key), other.Get(key); a != b {
+			t.Fatalf("%s: expected the same address whatever the order but got %s and %s", key, a, b)
+		}
+	}
+}
+
+func TestCacheRemoteSharded(t *testing.T) {
+	var remotes []string
+	var stores []server.Store
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		store := server.NewMemoryStore()
+		go http.Serve(ln, server.NewHandler(store))
+		remotes = append(remotes, remotescheme+ln.Addr().String())
+		stores = append(stores, store)
+	}
+
+	var n uint32
+	cachedHandler := httpcache.CacheRemoteSharded(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte("body of " + req.URL.Path))
+	}), cacheDuration, remotes...)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	const paths = 20
+	for i := 0; i < paths; i++ {
+		path := "/" + strconv.Itoa(i)
+		e.GET(path).Expect().Status(http.StatusOK).Body().Equal("body of " + path)
+		e.GET(path).Expect().Status(http.StatusOK).Body().Equal("body of " + path)
+	}
+
+	if got := atomic.LoadUint32(&n); got != paths {
+		t.Fatalf("expected %d executions of the original handler but got %d", paths, got)
+	}
+	if stores[0].Len() == 0 || stores[1].Len() == 0 || stores[0].Len()+stores[1].Len() != paths {
+		t.Fatalf("expected the entries to be distributed but got %d and %d", stores[0].Len(), stores[1].Len())
+	}
+}
+
+func TestCacheRemoteChunks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	remoteHandler := server.NewHandler(server.NewMemoryStore())
+	var contentLength int64
+	go http.Serve(ln, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			atomic.StoreInt64(&contentLength, req.ContentLength)
+		}
+		remoteHandler.ServeHTTP(res, req)
+	}))
+
+	chunks := []string{"first ", "second ", "third"}
+	cachedHandler := httpcache.CacheRemote(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		for _, chunk := range chunks {
+			res.Write([]byte(chunk))
+		}
+	}), cacheDuration, remotescheme+ln.Addr().String())
+
+	expected := strings.Join(chunks, "")
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expected)
+	if got := atomic.LoadInt64(&contentLength); got != int64(len(expected)) {
+		t.Fatalf("expected the stored body's Content-Length to be %d but got %d", len(expected), got)
+	}
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expected)
+}
+
+func TestCacheRemoteMissBody(t *testing.T) {
+	// a remote cache server which describes its misses, their bodies are drained
+	// and the connection is reused by the next requests.
+	var conns uint32
+	srv := stdhttptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			res.WriteHeader(cfg.FailStatus)
+			res.Write([]byte("miss"))
+			return
+		}
+		res.WriteHeader(cfg.SuccessStatus)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddUint32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	e := httptest.New(t, httptest.Handler(httpcache.CacheRemote(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration, srv.URL)))
+	for i := 0; i < 3; i++ {
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+	if got := atomic.LoadUint32(&conns); got != 1 {
+		t.Fatalf("expected the remote requests to reuse 1 connection but got %d", got)
+	}
+}
+
+func TestCacheRemoteKeyPrefix(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	store := server.NewMemoryStore()
+	go http.Serve(ln, server.NewHandler(store))
+
+	var n uint32
+	bodyHandler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	})
+	remote := remotescheme + ln.Addr().String()
+
+	for _, prefix := range []string{"v1|", "v1|", "v2|"} {
+		e := httptest.New(t, httptest.Handler(httpcache.CacheRemote(bodyHandler, cacheDuration, remote).KeyPrefix(prefix)))
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+
+	// the bumped prefix doesn't reuse the previous entry.
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected 2 executions of the original handler but got %d", got)
+	}
+	if got := store.Len(); got != 2 {
+		t.Fatalf("expected 2 remote entries but got %d", got)
+	}
+}
+
+func TestCacheRemoteStoreWorkers(t *testing.T) {
+	const workers = 2
+	bodyHandler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(expectedBodyStr))
+	})
+	fasthttpBodyHandler := func(reqCtx *fasthttp.RequestCtx) {
+		reqCtx.Write([]byte(expectedBodyStr))
+	}
+
+	stacks := map[string]func(remote string) func(path string) string{
+		"net/http": func(remote string) func(path string) string {
+			clientHandler := httpcache.CacheRemote(bodyHandler, cacheDuration, remote).StoreWorkers(workers)
+			return func(path string) string {
+				rec := stdhttptest.NewRecorder()
+				clientHandler.ServeHTTP(rec, stdhttptest.NewRequest(http.MethodGet, path, nil))
+				return rec.Body.String()
+			}
+		},
+		"fasthttp": func(remote string) func(path string) string {
+			clientHandler := httpcache.CacheRemoteFasthttp(fasthttpBodyHandler, cacheDuration, remote).StoreWorkers(workers)
+			return func(path string) string {
+				reqCtx := new(fasthttp.RequestCtx)
+				reqCtx.Request.SetRequestURI(path)
+				clientHandler.ServeHTTP(reqCtx)
+				return string(reqCtx.Response.Body())
+			}
+		},
+	}
+
+	for name, newServe := range stacks {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the remote posts hang until they are released.
+		store := server.NewMemoryStore()
+		remoteHandler := server.NewHandler(store)
+		release := make(chan struct{})
+		var inflight, maxInflight int32
+		go http.Serve(ln, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				n := atomic.AddInt32(&inflight, 1)
+				for m := atomic.LoadInt32(&maxInflight); n > m && !atomic.CompareAndSwapInt32(&maxInflight, m, n); m = atomic.LoadInt32(&maxInflight) {
+				}
+				<-release
+				atomic.AddInt32(&inflight, -1)
+			}
+			remoteHandler.ServeHTTP(res, req)
+		}))
+
+		serve := newServe(remotescheme + ln.Addr().String())
+		serveAsync := func(path string) chan string {
+			body := make(chan string, 1)
+			go func() { body <- serve(path) }()
+			return body
+		}
+
+		// the responses don't wait for their hanging posts while there are free workers.
+		for i := 0; i < workers; i++ {
+			select {
+			case body := <-serveAsync(fmt.Sprintf("/%d", i)):
+				if body != expectedBodyStr {
+					t.Fatalf("[%s] expected the body to be %q but got %q", name, expectedBodyStr, body)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("[%s] expected the response %d not to be delayed by its background store", name, i)
+			}
+		}
+
+		// all the workers are busy, the next store waits for one of them.
+		pending := serveAsync("/pending")
+		select {
+		case <-pending:
+			t.Fatalf("[%s] expected the store to wait for a free worker", name)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if got := atomic.LoadInt32(&maxInflight); got != workers {
+			t.Fatalf("[%s] expected %d concurrent remote posts but got %d", name, workers, got)
+		}
+
+		close(release)
+		select {
+		case body := <-pending:
+			if body != expectedBodyStr {
+				t.Fatalf("[%s] expected the body to be %q but got %q", name, expectedBodyStr, body)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] expected the store to continue after a worker is released", name)
+		}
+
+		// the released posts are stored.
+		deadline := time.Now().Add(time.Second)
+		for store.Len() != workers+1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := store.Len(); got != workers+1 {
+			t.Fatalf("[%s] expected %d remote entries but got %d", name, workers+1, got)
+		}
+		if got := atomic.LoadInt32(&maxInflight); got > workers {
+			t.Fatalf("[%s] expected at most %d concurrent remote posts but got %d", name, workers, got)
+		}
+		ln.Close()
+	}
+}
+
+func TestCacheFasthttpBodyCopy(t *testing.T) {
+	cachedHandler := httpcache.CacheFasthttpFunc(func(reqCtx *fasthttp.RequestCtx) {
+		reqCtx.SetBodyString("body of " + string(reqCtx.Path()))
+	}, cacheDuration)
+
+	ln := fasthttputil.NewInmemoryListener()
+	srv := &fasthttp.Server{Handler: cachedHandler}
+	go srv.Serve(ln)
+	defer ln.Close()
+
+	client := &fasthttp.Client{Dial: func(string) (net.Conn, error) { return ln.Dial() }}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the same few paths, their pooled contexts are reused by the others.
+			for j := 0; j < 50; j++ {
+				path := "/" + strconv.Itoa((i+j)%4)
+				_, body, err := client.Get(nil, "http://localhost"+path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if expected := "body of " + path; string(body) != expected {
+					t.Errorf("expected %q but got %q", expected, body)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCacheHeaders(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Language", "en")
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).CacheHeaders().CacheStatusHeader("X-Cache")
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Header("Content-Language").Equal("en")
+	r := e.GET("/").Expect().Status(http.StatusOK)
+	r.Header("X-Cache").Equal(cfg.CacheStatusHit)
+	r.Header("Content-Language").Equal("en")
+	r.Body().Equal(expectedBodyStr)
+}
+
+func TestCachePreflight(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Access-Control-Allow-Origin", req.Header.Get("Origin"))
+		res.Header().Set("Access-Control-Allow-Methods", "GET, PUT")
+		res.WriteHeader(http.StatusNoContent)
+	}), cacheDuration).CachePreflight()
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	preflight := func(origin, headers string) *httpexpect.Response {
+		return e.OPTIONS("/").WithHeader("Origin", origin).
+			WithHeader("Access-Control-Request-Method", "PUT").
+			WithHeader("Access-Control-Request-Headers", headers).Expect()
+	}
+
+	preflight("http://a.com", "X-A, X-B").Status(http.StatusNoContent)
+	// the order of the requested headers doesn't matter.
+	r := preflight("http://a.com", "x-b, x-a").Status(http.StatusNoContent)
+	r.Header("Access-Control-Allow-Origin").Equal("http://a.com")
+	r.Header("Access-Control-Allow-Methods").Equal("GET, PUT")
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the handler to be executed once but executed %d", got)
+	}
+
+	// each origin is cached separately.
+	preflight("http://b.com", "X-A, X-B").Status(http.StatusNoContent).
+		Header("Access-Control-Allow-Origin").Equal("http://b.com")
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the handler to be executed 2 times but executed %d", got)
+	}
+}
+
+func TestKeyByStatus(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		if req.Header.Get("Range") != "" {
+			res.WriteHeader(http.StatusPartialContent)
+			res.Write([]byte(expectedBodyStr[:7]))
+			return
+		}
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).KeyByStatus(func(req *http.Request) int {
+		if req.Header.Get("Range") != "" {
+			return http.StatusPartialContent
+		}
+		return http.StatusOK
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for i := 0; i < 2; i++ {
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/").WithHeader("Range", "bytes=0-6").Expect().Status(http.StatusPartialContent).Body().Equal(expectedBodyStr[:7])
+	}
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the handler to be executed 2 times but executed %d", got)
+	}
+}
+
+func TestPreflightKey(t *testing.T) {
+	if a, b := entry.PreflightKey("http://a.com", "put", "X-A, x-b"), entry.PreflightKey("http://a.com", "PUT", "x-b,x-a"); a != b {
+		t.Fatalf("expected the equivalent preflight keys to be equal but got %q and %q", a, b)
+	}
+	if a, b := entry.PreflightKey("http://a.com", "PUT", ""), entry.PreflightKey("http://b.com", "PUT", ""); a == b {
+		t.Fatalf("expected the preflight keys of different origins to differ but got %q", a)
+	}
+}
+
+func TestCacheableHeader(t *testing.T) {
+	tests := []struct {
+		name, connection string
+		expected         bool
+	}{
+		{"Content-Language", "", true},
+		{"X-Trace", "keep-alive", true},
+		{"Connection", "", false},
+		{"Keep-Alive", "", false},
+		{"Transfer-Encoding", "", false},
+		{"Upgrade", "", false},
+		{"Proxy-Authenticate", "", false},
+		{"Te", "", false},
+		{"Trailer", "", false},
+		{"X-Trace", "close, x-trace", false},
+		{"Content-Length", "", false},
+		{"Set-Cookie", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := entry.CacheableHeader(tt.name, tt.connection); got != tt.expected {
+			t.Fatalf("%s (connection: %q): expected %v but got %v", tt.name, tt.connection, tt.expected, got)
+		}
+	}
+}
+
+func TestCacheFasthttpContentType(t *testing.T) {
+	html := "<!DOCTYPE html><html><body>" + expectedBodyStr + "</body></html>"
+	var n uint32
+	cachedHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		atomic.AddUint32(&n, 1)
+		if string(reqCtx.Path()) == "/text" {
+			// the fasthttp's default content type, set by the handler.
+			reqCtx.SetContentType("text/plain; charset=utf-8")
+		}
+		reqCtx.SetBodyString(html)
+	}, cacheDuration)
+
+	e := httptest.New(t, httptest.RequestHandler(cachedHandler.ServeHTTP))
+	for i := 0; i < 2; i++ {
+		// the detected content type is sent by the miss and by the hit.
+		e.GET("/").Expect().Status(http.StatusOK).Header("Content-Type").Equal("text/html; charset=utf-8")
+		e.GET("/text").Expect().Status(http.StatusOK).Header("Content-Type").Equal("text/plain; charset=utf-8")
+	}
+
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the original handler to be executed 2 times but executed %d times", got)
+	}
+}
+
+func TestCacheFasthttpHeaders(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		atomic.AddUint32(&n, 1)
+		reqCtx.Response.Header.Set("Content-Language", "en")
+		reqCtx.Response.Header.Add("Link", "</a.css>; rel=preload")
+		reqCtx.Response.Header.Add("Link", "</b.js>; rel=preload")
+		reqCtx.SetBodyString(expectedBodyStr)
+	}, cacheDuration).CacheHeaders()
+
+	ln := fasthttputil.NewInmemoryListener()
+	srv := &fasthttp.Server{Handler: cachedHandler.ServeHTTP}
+	go srv.Serve(ln)
+	defer ln.Close()
+
+	client := &fasthttp.Client{Dial: func(string) (net.Conn, error) { return ln.Dial() }}
+	for i := 0; i < 2; i++ {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.SetRequestURI("http://localhost/")
+		if err := client.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(res.Header.Peek("Content-Language")); got != "en" {
+			t.Fatalf("expected the Content-Language header but got %q", got)
+		}
+		var links []string
+		res.Header.VisitAll(func(k, v []byte) {
+			if string(k) == "Link" {
+				links = append(links, string(v))
+			}
+		})
+		if len(links) != 2 {
+			t.Fatalf("expected 2 Link headers but got %v", links)
+		}
+		if string(res.Body()) != expectedBodyStr {
+			t.Fatalf("unexpected body %q", res.Body())
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected one execution of the original handler but got %d", got)
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(req.URL.Path))
+	}), cacheDuration).Expiration(func(statusCode int, contentType string, body []byte) time.Duration {
+		if string(body) == "/live" {
+			// don't cache.
+			return 0
+		}
+		return cacheDuration
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/live").Expect().Status(http.StatusOK).Body().Equal("/live")
+	e.GET("/live").Expect().Status(http.StatusOK).Body().Equal("/live")
+	e.GET("/static").Expect().Status(http.StatusOK).Body().Equal("/static")
+	e.GET("/static").Expect().Status(http.StatusOK).Body().Equal("/static")
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 3 {
+		t.Fatal(errTestFailed.Format(3, counter))
+	}
+}
+
+func TestCacheTransform(t *testing.T) {
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("  body  "))
+	}), cacheDuration).TransformStored(bytes.TrimSpace).TransformServed(func(body []byte) []byte {
+		return append(append([]byte(nil), body...), "<!-- cached -->"...)
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	// the original response.
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("  body  ")
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("body<!-- cached -->")
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal("body<!-- cached -->")
+}
+
+func TestStoreMulti(t *testing.T) {
+	boltStore, err := server.NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.(io.Closer).Close()
+
+	for _, store := range []server.Store{server.NewMemoryStore(), boltStore} {
+		entries := make(map[string]*entry.Entry)
+		for _, key := range []string{"GEThttp:///a", "GEThttp:///b"} {
+			e := entry.NewEntry(cacheDuration)
+			e.Reset(http.StatusOK, "text/plain", []byte(key), nil)
+			entries[key] = e
+		}
+		server.SetMulti(store, entries)
+
+		got := server.GetMulti(store, []string{"GEThttp:///a", "GEThttp:///b", "GEThttp:///c"})
+		if len(got) != 2 {
+			t.Fatalf("%T: expected 2 entries but got %d", store, len(got))
+		}
+		for key, e := range got {
+			if res, ok := e.Response(); !ok || string(res.Body()) != key {
+				t.Fatalf("%T: unexpected entry of %s", store, key)
+			}
+		}
+	}
+}
+
+func TestStoreWatermarks(t *testing.T) {
+	store := server.NewMemoryStoreLFUWithWatermarks(0, 0, 100, 50)
+	body := bytes.Repeat([]byte("a"), 30)
+
+	store.Set("a", http.StatusOK, "text/plain", body, cacheDuration)
+	store.Set("b", http.StatusOK, "text/plain", body, cacheDuration)
+	store.Set("c", http.StatusOK, "text/plain", body, cacheDuration)
+	// "a" and "c" are hot.
+	store.Get("a")
+	store.Get("c")
+	if n := store.(server.ByteCounter).Bytes(); n != 90 {
+		t.Fatalf("expected 90 bytes but got %d", n)
+	}
+
+	// 120 bytes exceed the high watermark, the entries are evicted down to the low one.
+	store.Set("d", http.StatusOK, "text/plain", body, cacheDuration)
+	if n := store.(server.ByteCounter).Bytes(); n > 50 {
+		t.Fatalf("expected at most 50 bytes but got %d", n)
+	}
+	if store.Get("d") == nil {
+		t.Fatalf("expected the new entry to be stored")
+	}
+	if store.Get("b") != nil {
+		t.Fatalf("expected the least frequently used entry to be evicted")
+	}
+}
+
+// lfuKeys returns the sorted keys of the "store", without touching their frequencies.
+func lfuKeys(store server.Store) string {
+	keys := store.Keys()
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func TestStoreLFUEviction(t *testing.T) {
+	store := server.NewMemoryStoreLFU(0, 3)
+	for _, key := range []string{"a", "b", "c"} {
+		store.Set(key, http.StatusOK, "text/plain", []byte(key), cacheDuration)
+	}
+	// "a" is the hottest, "c" is the coldest.
+	for i := 0; i < 3; i++ {
+		store.Get("a")
+	}
+	store.Get("b")
+
+	store.Set("d", http.StatusOK, "text/plain", []byte("d"), cacheDuration)
+	if keys := lfuKeys(store); keys != "a,b,d" {
+		t.Fatalf("expected the least frequently used entry to be evicted but got %s", keys)
+	}
+
+	// the frequency of a renewed entry is kept.
+	store.Set("a", http.StatusOK, "text/plain", []byte("a2"), cacheDuration)
+	store.Get("d")
+	store.Get("d")
+	store.Set("e", http.StatusOK, "text/plain", []byte("e"), cacheDuration)
+	if keys := lfuKeys(store); keys != "a,d,e" {
+		t.Fatalf("expected the renewed entry to keep its frequency but got %s", keys)
+	}
+
+	// the expired entries are evicted first, whatever their frequency is.
+	expired := entry.NewEntryMinimum(10*time.Millisecond, 0)
+	expired.Reset(http.StatusOK, "text/plain", []byte("f"), nil)
+	store.Remove("e")
+	store.(server.EntrySetter).SetEntry("f", expired)
+	for i := 0; i < 10; i++ {
+		store.Get("f")
+	}
+	time.Sleep(20 * time.Millisecond)
+	store.Set("g", http.StatusOK, "text/plain", []byte("g"), cacheDuration)
+	if keys := lfuKeys(store); keys != "a,d,g" {
+		t.Fatalf("expected the expired entry to be evicted but got %s", keys)
+	}
+}
+
+func TestStoreHooks(t *testing.T) {
+	boltStore, err := server.NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.(io.Closer).Close()
+
+	for _, store := range []server.Store{server.NewMemoryStore(), server.NewSyncMapStore(0), server.NewMemoryStoreLFU(0, 0), boltStore} {
+		var (
+			mu             sync.Mutex
+			setKeys        []string
+			evictedKeys    []string
+			setFoundStored bool
+		)
+		notifier := store.(server.Notifier)
+		// the callbacks call the store, they should not deadlock.
+		notifier.OnSet(func(key string, e *entry.Entry) {
+			stored := store.Get(key) != nil
+			mu.Lock()
+			setKeys = append(setKeys, key)
+			setFoundStored = stored
+			mu.Unlock()
+		})
+		notifier.OnEvict(func(key string, e *entry.Entry) {
+			store.Len()
+			mu.Lock()
+			evictedKeys = append(evictedKeys, key)
+			mu.Unlock()
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			store.Set("GET/valid", http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+			expired := entry.NewEntryMinimum(10*time.Millisecond, 0)
+			expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+			store.(server.EntrySetter).SetEntry("GET/expired", expired)
+			time.Sleep(20 * time.Millisecond)
+			server.ClearExpired(store)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%T: the callbacks deadlocked the store", store)
+		}
+
+		mu.Lock()
+		if len(setKeys) != 2 || setKeys[0] != "GET/valid" || setKeys[1] != "GET/expired" || !setFoundStored {
+			t.Fatalf("%T: expected the OnSet to fire after each set but got %v, stored: %v", store, setKeys, setFoundStored)
+		}
+		if len(evictedKeys) != 1 || evictedKeys[0] != "GET/expired" {
+			t.Fatalf("%T: expected the OnEvict to fire for the expired entry but got %v", store, evictedKeys)
+		}
+		mu.Unlock()
+		if got := store.(server.EvictionCounter).Evictions(); got != 1 {
+			t.Fatalf("%T: expected 1 eviction but got %d", store, got)
+		}
+	}
+}
+
+func TestStoreHooksGC(t *testing.T) {
+	// the background scan and the LFU's eviction fire the OnEvict too.
+	evicted := make(chan string, 2)
+	store := server.NewMemoryStore()
+	store.(server.Notifier).OnEvict(func(key string, _ *entry.Entry) {
+		store.Keys()
+		evicted <- key
+	})
+	expired := entry.NewEntryMinimum(10*time.Millisecond, 0)
+	expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+	store.(server.EntrySetter).SetEntry("GET/expired", expired)
+	store.(server.GarbageCollector).SetGCInterval(50 * time.Millisecond)
+	defer store.(server.GarbageCollector).SetGCInterval(0)
+	select {
+	case key := <-evicted:
+		if key != "GET/expired" {
+			t.Fatalf("expected the expired entry to be evicted by the scan but got %s", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the scan to fire the OnEvict")
+	}
+
+	lfu := server.NewMemoryStoreLFU(0, 1)
+	lfu.(server.Notifier).OnEvict(func(key string, _ *entry.Entry) {
+		lfu.Get(key)
+		evicted <- key
+	})
+	lfu.Set("a", http.StatusOK, "text/plain", []byte("a"), cacheDuration)
+	lfu.Set("b", http.StatusOK, "text/plain", []byte("b"), cacheDuration)
+	select {
+	case key := <-evicted:
+		if key != "a" {
+			t.Fatalf("expected the least frequently used entry to be evicted but got %s", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the LFU eviction to fire the OnEvict")
+	}
+}
+
+func TestStoreGCJitter(t *testing.T) {
+	// each scan evicts the expired entry which is set after the previous one,
+	// the time between the evictions is the interval of each tick.
+	const interval = 50 * time.Millisecond
+	evicted := make(chan time.Time, 1)
+	store := server.NewMemoryStore()
+	store.(server.Notifier).OnEvict(func(string, *entry.Entry) {
+		evicted <- time.Now()
+	})
+	setExpired := func() {
+		expired := entry.NewEntryMinimum(time.Nanosecond, 0)
+		expired.Reset(http.StatusOK, "text/plain", []byte(expectedBodyStr), nil)
+		store.(server.EntrySetter).SetEntry("GET/expired", expired)
+	}
+	waitEvicted := func() time.Time {
+		select {
+		case at := <-evicted:
+			return at
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the scan to evict the expired entry")
+			return time.Time{}
+		}
+	}
+
+	last := time.Now()
+	store.(server.GarbageCollector).SetGCInterval(interval)
+	defer store.(server.GarbageCollector).SetGCInterval(0)
+
+	// the ticks deviate up to a tenth of the interval, the stores don't sweep together,
+	// some of them are earlier than the interval.
+	earlier := 0
+	for i := 0; i < 20; i++ {
+		setExpired()
+		at := waitEvicted()
+		tick := at.Sub(last)
+		if tick < interval-interval/10 || tick > interval+interval/10+50*time.Millisecond {
+			t.Fatalf("[%d] expected the tick to be within 10%% of %s but got %s", i, interval, tick)
+		}
+		if tick < interval {
+			earlier++
+		}
+		last = at
+	}
+	if earlier == 0 {
+		t.Fatalf("expected some of the ticks to be earlier than %s", interval)
+	}
+
+	// a tiny interval can't deviate, the scan still runs at it.
+	store.(server.GarbageCollector).SetGCInterval(5 * time.Nanosecond)
+	setExpired()
+	waitEvicted()
+}
+
+func TestStoreLFUPeek(t *testing.T) {
+	store := server.NewMemoryStoreLFU(0, 2)
+	store.Set("a", http.StatusOK, "text/plain", []byte("a"), cacheDuration)
+	store.Set("b", http.StatusOK, "text/plain", []byte("b"), cacheDuration)
+	store.Get("a")
+	// the scans of the entries don't count as uses.
+	for i := 0; i < 3; i++ {
+		if e := server.Peek(store, "b"); e == nil {
+			t.Fatal("expected the peeked entry")
+		}
+	}
+	if e := server.Peek(store, "c"); e != nil {
+		t.Fatal("expected no entry of a missing key")
+	}
+
+	store.Set("c", http.StatusOK, "text/plain", []byte("c"), cacheDuration)
+	if keys := lfuKeys(store); keys != "a,c" {
+		t.Fatalf("expected the peeked entry to be evicted but got %s", keys)
+	}
+}
+
+func TestStoreLFUTieBreaking(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		store := server.NewMemoryStoreLFU(0, 3)
+		for _, key := range []string{"a", "b", "c"} {
+			store.Set(key, http.StatusOK, "text/plain", []byte(key), cacheDuration)
+		}
+
+		// all of them have the same frequency, the oldest is evicted.
+		store.Set("d", http.StatusOK, "text/plain", []byte("d"), cacheDuration)
+		if keys := lfuKeys(store); keys != "b,c,d" {
+			t.Fatalf("expected the oldest entry to be evicted but got %s", keys)
+		}
+
+		store.Get("b")
+		store.Get("c")
+		store.Get("d")
+		store.Set("e", http.StatusOK, "text/plain", []byte("e"), cacheDuration)
+		if keys := lfuKeys(store); keys != "c,d,e" {
+			t.Fatalf("expected the oldest entry of the same frequency to be evicted but got %s", keys)
+		}
+	}
+}
+
+func TestStoreLFUDecay(t *testing.T) {
+	store := server.NewMemoryStoreLFU(50*time.Millisecond, 2)
+	defer store.(io.Closer).Close()
+
+	store.Set("a", http.StatusOK, "text/plain", []byte("a"), cacheDuration)
+	// "a" was popular once.
+	for i := 0; i < 8; i++ {
+		store.Get("a")
+	}
+	// each scan halves its frequency, down to zero.
+	time.Sleep(400 * time.Millisecond)
+
+	store.Set("b", http.StatusOK, "text/plain", []byte("b"), cacheDuration)
+	store.Get("b")
+	store.Set("c", http.StatusOK, "text/plain", []byte("c"), cacheDuration)
+	if keys := lfuKeys(store); keys != "b,c" {
+		t.Fatalf("expected the once-popular entry to be evicted after its decay but got %s", keys)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"gzip, deflate", true},
+		{"deflate, GZIP", true},
+		{"deflate", false},
+		{"", false},
+		{"gzip;q=0", false},
+		{"gzip; q=0.5", true},
+		{"*", true},
+		{"*;q=0", false},
+		{"*, gzip;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+	}
+
+	for _, tt := range tests {
+		if got := entry.AcceptsEncoding(tt.header, "gzip"); got != tt.expected {
+			t.Fatalf("%q: expected %v but got %v", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected int64
+	}{
+		{"max-age=60", 60},
+		{"public, max-age=3600", 3600},
+		{"public, max-age=3600, s-maxage=60", 60},
+		{"s-maxage=60, max-age=3600", 60},
+		{"private, max-age = 30, must-revalidate", 30},
+		{`max-age="120"`, 120},
+		{"MAX-AGE=15", 15},
+		{"maxage=10", 10},
+		{"no-cache", -1},
+		{"max-age=invalid", -1},
+		{"", -1},
+	}
+
+	for _, tt := range tests {
+		if got := entry.ParseMaxAge(tt.header); got != tt.expected {
+			t.Fatalf("%q: expected %d but got %d", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestCacheControlRule(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		expected     bool
+	}{
+		{"", true},
+		{"public", true},
+		{"public, max-age=3600", true},
+		{"max-age=60, must-revalidate", true},
+		{"no-cache", true},
+		{"no-store", false},
+		{"public, No-Store", false},
+		{"private", false},
+		{"private, max-age=60", false},
+		{`private="Set-Cookie", max-age=60`, false},
+		{"max-age=0", false},
+		{"public, max-age=3600, s-maxage=0", false},
+		{"s-maxage=60, max-age=0", true},
+		// revalidated.
+		{"no-cache, max-age=0", true},
+	}
+
+	for _, tt := range tests {
+		header := func(key string) string {
+			if key == "Cache-Control" {
+				return tt.cacheControl
+			}
+			return ""
+		}
+		if got := ruleset.CacheControlRule(header); got != tt.expected {
+			t.Fatalf("%q: expected %v but got %v", tt.cacheControl, tt.expected, got)
+		}
+	}
+}
+
+func TestCacheControlDefaultRule(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Cache-Control", req.URL.Query().Get("cc"))
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	for _, cacheControl := range []string{"no-store", "private, max-age=60", "max-age=0"} {
+		e.GET("/").WithQuery("cc", cacheControl).Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/").WithQuery("cc", cacheControl).Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+	if got := atomic.LoadUint32(&n); got != 6 {
+		t.Fatalf("expected the handler to be executed 6 times but executed %d", got)
+	}
+
+	e.GET("/").WithQuery("cc", "public, max-age=60").Expect().Status(http.StatusOK)
+	e.GET("/").WithQuery("cc", "public, max-age=60").Expect().Status(http.StatusOK)
+	if got := atomic.LoadUint32(&n); got != 7 {
+		t.Fatalf("expected the handler to be executed 7 times but executed %d", got)
+	}
+}
+
+func TestCacheControlDirectives(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Cache-Control", "no-cache, max-age=0")
+		res.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	// the "no-cache, max-age=0" response is stored and revalidated.
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if got := cachedHandler.GetStore().Len(); got != 1 {
+		t.Fatalf("expected the response to be stored but got %d entries", got)
+	}
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the handler to revalidate the response but executed %d times", got)
+	}
+
+	// the handler's directives are authoritative.
+	atomic.StoreUint32(&n, 0)
+	cachedHandler = httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Header().Set("Cache-Control", "private, max-age=60")
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).Directive("private", ruleset.StoreBehavior)
+	e = httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	if got := atomic.LoadUint32(&n); got != 1 {
+		t.Fatalf("expected the private response to be stored but the handler executed %d times", got)
+	}
+}
+
+func TestURIBuilderKey(t *testing.T) {
+	const (
+		clientURI   = "/a%20b/c?x=1&y=%2F%20+z"
+		expectedKey = "GEThttp://" + clientURI
+	)
+
+	for _, addr := range []string{"http://127.0.0.1:8888", "127.0.0.1:8888"} {
+		get := &uri.URIBuilder{}
+		get.ServerAddr(addr).ClientURI(clientURI).ClientMethod("GET")
+		post := &uri.URIBuilder{}
+		post.ServerAddr(addr).ClientURI(clientURI).ClientMethod("GET").
+			StatusCode(http.StatusOK).Lifetime(time.Minute).ContentType("text/plain; charset=utf-8")
+
+		for _, s := range []string{get.String(), post.String()} {
+			u, err := url.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Scheme != "http" || u.Host != "127.0.0.1:8888" {
+				t.Fatalf("%s: unexpected remote url %s", addr, s)
+			}
+			if key := u.Query().Get(cfg.QueryCacheKey); key != expectedKey {
+				t.Fatalf("%s: expected key %s but got %s", addr, expectedKey, key)
+			}
+
+			// the fasthttp clients send the same key.
+			fu := fasthttp.AcquireURI()
+			fu.Update(s)
+			u, err = url.Parse(string(fu.FullURI()))
+			fasthttp.ReleaseURI(fu)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key := u.Query().Get(cfg.QueryCacheKey); key != expectedKey {
+				t.Fatalf("%s: expected fasthttp key %s but got %s", addr, expectedKey, key)
+			}
+		}
+	}
+}
+
+func benchmarkStoreGet(b *testing.B, store server.Store) {
+	const keys = 1024
+	for i := 0; i < keys; i++ {
+		store.Set("GEThttp:///"+strconv.Itoa(i), http.StatusOK, "text/plain", []byte(expectedBodyStr), cacheDuration)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			store.Get("GEThttp:///" + strconv.Itoa(i%keys))
+			i++
+		}
+	})
+}
+
+func BenchmarkMemoryStoreGet(b *testing.B) {
+	benchmarkStoreGet(b, server.NewMemoryStore())
+}
+
+func BenchmarkSyncMapStoreGet(b *testing.B) {
+	benchmarkStoreGet(b, server.NewSyncMapStore(0))
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+func benchmarkResponseRecorderBody(b *testing.B, writes int) {
+	w := &discardResponseWriter{header: make(http.Header)}
+	contents := []byte(expectedBodyStr)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res := nethttp.AcquireResponseRecorder(w)
+		for j := 0; j < writes; j++ {
+			res.Write(contents)
+		}
+		if len(res.Body()) != writes*len(contents) {
+			b.Fatalf("unexpected body's size")
+		}
+		nethttp.ReleaseResponseRecorder(res)
+	}
+}
+
+func BenchmarkResponseRecorderBodySingleWrite(b *testing.B) {
+	benchmarkResponseRecorderBody(b, 1)
+}
+
+func BenchmarkResponseRecorderBodyMultipleWrites(b *testing.B) {
+	benchmarkResponseRecorderBody(b, 4)
+}
+
+func TestCacheRefreshing(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}()
+
+	<-started
+	if !cachedHandler.Refreshing("/") {
+		t.Fatal("expected the response to be refreshing while the original handler is executing")
+	}
+	close(release)
+	<-done
+	if cachedHandler.Refreshing("/") {
+		t.Fatal("expected the response to not be refreshing after the original handler is executed")
+	}
+}
+
+func TestCacheQueryParams(t *testing.T) {
+	var n, fn uint32
+	body := func(q, page string) string {
+		return "q=" + q + ", page=" + page
+	}
+	newHandler := func() *nethttp.Handler {
+		return httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			atomic.AddUint32(&n, 1)
+			res.Write([]byte(body(req.URL.Query().Get("q"), req.URL.Query().Get("page"))))
+		}), cacheDuration)
+	}
+	newFasthttpHandler := func() *fhttp.Handler {
+		return httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+			atomic.AddUint32(&fn, 1)
+			reqCtx.WriteString(body(string(reqCtx.QueryArgs().Peek("q")), string(reqCtx.QueryArgs().Peek("page"))))
+		}, cacheDuration)
+	}
+
+	tests := []struct {
+		name            string
+		handler         http.Handler
+		fasthttpHandler *fhttp.Handler
+	}{
+		{"CacheQueryParams", newHandler().CacheQueryParams("q", "page"), newFasthttpHandler().CacheQueryParams("q", "page")},
+		{"IgnoreQueryParams", newHandler().IgnoreQueryParams("utm_source", "utm_medium"), newFasthttpHandler().IgnoreQueryParams("utm_source", "utm_medium")},
+	}
+
+	for _, tt := range tests {
+		atomic.StoreUint32(&n, 0)
+		atomic.StoreUint32(&fn, 0)
+		for _, e := range []*httpexpect.Expect{
+			httptest.New(t, httptest.Handler(tt.handler)),
+			httptest.New(t, httptest.RequestHandler(tt.fasthttpHandler.ServeHTTP)),
+		} {
+			e.GET("/search").WithQuery("q", "golang").WithQuery("page", 2).WithQuery("utm_source", "x").
+				Expect().Status(http.StatusOK).Body().Equal(body("golang", "2"))
+			// the utm_* parameters don't participate in the key, nor their order.
+			e.GET("/search").WithQuery("page", 2).WithQuery("q", "golang").
+				Expect().Status(http.StatusOK).Body().Equal(body("golang", "2"))
+			e.GET("/search").WithQuery("q", "golang").WithQuery("page", 2).WithQuery("utm_medium", "y").
+				Expect().Status(http.StatusOK).Body().Equal(body("golang", "2"))
+			// the q and the page do.
+			e.GET("/search").WithQuery("q", "golang").WithQuery("page", 3).
+				Expect().Status(http.StatusOK).Body().Equal(body("golang", "3"))
+			e.GET("/search").WithQuery("q", "rust").WithQuery("page", 2).
+				Expect().Status(http.StatusOK).Body().Equal(body("rust", "2"))
+		}
+
+		if got := atomic.LoadUint32(&n); got != 3 {
+			t.Fatalf("%s: expected the original handler to be executed 3 times but executed %d times", tt.name, got)
+		}
+		if got := atomic.LoadUint32(&fn); got != 3 {
+			t.Fatalf("%s: expected the original fasthttp handler to be executed 3 times but executed %d times", tt.name, got)
+		}
+	}
+}
+
+func TestCachePragmaNoCache(t *testing.T) {
+	var n, fn uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration)
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		atomic.AddUint32(&fn, 1)
+		reqCtx.Write([]byte(expectedBodyStr))
+	}, cacheDuration)
+
+	for _, e := range []*httpexpect.Expect{
+		httptest.New(t, httptest.Handler(cachedHandler)),
+		httptest.New(t, httptest.RequestHandler(fasthttpHandler.ServeHTTP)),
+	} {
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		// the cached response is not served.
+		e.GET("/").WithHeader("Pragma", "no-cache").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		// neither its response is stored.
+		e.GET("/other").WithHeader("Pragma", "No-Cache").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/other").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+
+	if got := atomic.LoadUint32(&n); got != 4 {
+		t.Fatalf("expected the original handler to be executed 4 times but executed %d times", got)
+	}
+	if got := atomic.LoadUint32(&fn); got != 4 {
+		t.Fatalf("expected the original fasthttp handler to be executed 4 times but executed %d times", got)
+	}
+}
+
+func TestCacheKeyByHost(t *testing.T) {
+	var n, fn uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(req.Host))
+	}), cacheDuration).KeyByHost()
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		atomic.AddUint32(&fn, 1)
+		reqCtx.Write(reqCtx.Host())
+	}, cacheDuration).KeyByHost()
+
+	serve := func(host string) string {
+		req := stdhttptest.NewRequest(http.MethodGet, "http://"+host+"/articles", nil)
+		rec := stdhttptest.NewRecorder()
+		cachedHandler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	serveFasthttp := func(host string) string {
+		reqCtx := new(fasthttp.RequestCtx)
+		reqCtx.Request.SetRequestURI("http://" + host + "/articles")
+		fasthttpHandler.ServeHTTP(reqCtx)
+		return string(reqCtx.Response.Body())
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, host := range []string{"a.example.com", "b.example.com"} {
+			// each host gets its own cached response.
+			if got := serve(host); got != host {
+				t.Fatalf("expected the response of %s but got %s", host, got)
+			}
+			if got := serveFasthttp(host); got != host {
+				t.Fatalf("expected the fasthttp response of %s but got %s", host, got)
+			}
+		}
+	}
+	if got := atomic.LoadUint32(&n); got != 2 {
+		t.Fatalf("expected the original handler to be executed 2 times but executed %d times", got)
+	}
+	if got := atomic.LoadUint32(&fn); got != 2 {
+		t.Fatalf("expected the original fasthttp handler to be executed 2 times but executed %d times", got)
+	}
+
+	// the invalidation of a host doesn't affect the rest of them.
+	cachedHandler.Invalidate("a.example.com/articles")
+	fasthttpHandler.Invalidate("a.example.com/articles")
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		serve(host)
+		serveFasthttp(host)
+	}
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+	if got := atomic.LoadUint32(&fn); got != 3 {
+		t.Fatalf("expected the original fasthttp handler to be executed 3 times but executed %d times", got)
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	tests := []struct {
+		key, expected string
+	}{
+		{"/Path/?q=Go", "/path?q=Go"},
+		{"/ARTICLES//", "/articles"},
+		{"/", "/"},
+		{"//", "/"},
+		{"/a/b", "/a/b"},
+	}
+	for _, tt := range tests {
+		if got := uri.NormalizeKey(tt.key); got != tt.expected {
+			t.Fatalf("%s: expected %s but got %s", tt.key, tt.expected, got)
+		}
+	}
+}
+
+func TestCacheKeyNormalizer(t *testing.T) {
+	var n, fn uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).IgnoreQueryParams("utm_source").KeyNormalizer(uri.NormalizeKey)
+	fasthttpHandler := httpcache.CacheFasthttp(func(reqCtx *fasthttp.RequestCtx) {
+		atomic.AddUint32(&fn, 1)
+		reqCtx.Write([]byte(expectedBodyStr))
+	}, cacheDuration).IgnoreQueryParams("utm_source").KeyNormalizer(uri.NormalizeKey)
+
+	for _, e := range []*httpexpect.Expect{
+		httptest.New(t, httptest.Handler(cachedHandler)),
+		httptest.New(t, httptest.RequestHandler(fasthttpHandler.ServeHTTP)),
+	} {
+		// the equivalent paths share the same cached response.
+		for _, path := range []string{"/Articles/", "/articles", "/ARTICLES?utm_source=x"} {
+			e.GET(path).Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		}
+		// the query is kept as it's.
+		e.GET("/articles").WithQuery("q", "Go").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+		e.GET("/articles").WithQuery("q", "go").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}
+	if got := atomic.LoadUint32(&n); got != 3 {
+		t.Fatalf("expected the original handler to be executed 3 times but executed %d times", got)
+	}
+	if got := atomic.LoadUint32(&fn); got != 3 {
+		t.Fatalf("expected the original fasthttp handler to be executed 3 times but executed %d times", got)
+	}
+
+	// the invalidation is normalized too.
+	cachedHandler.Invalidate("/Articles/")
+	fasthttpHandler.Invalidate("/Articles/")
+	if got := cachedHandler.GetStore().Len(); got != 2 {
+		t.Fatalf("expected the normalized entry to be invalidated but got %d entries", got)
+	}
+	if got := fasthttpHandler.GetStore().Len(); got != 2 {
+		t.Fatalf("expected the normalized fasthttp entry to be invalidated but got %d entries", got)
+	}
+}
+
+func TestCacheKeyFunc(t *testing.T) {
+	var n uint32
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&n, 1)
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).KeyFunc(func(req *http.Request) (string, bool) {
+		if req.URL.Path != "/feed" {
+			return "", false
+		}
+		// keyed by the page only.
+		return "/feed?page=" + req.URL.Query().Get("page"), true
+	})
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	e.GET("/feed").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/feed?utm_source=x").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/feed?page=2").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	// bypassed.
+	e.GET("/other").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	e.GET("/other").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+
+	counter := atomic.LoadUint32(&n)
+	if counter != 4 {
+		t.Fatal(errTestFailed.Format(4, counter))
+	}
+}
+
+func TestCacheMaxConcurrentOriginCalls(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).MaxConcurrentOriginCalls(1, 50*time.Millisecond)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.GET("/a").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}()
+
+	<-started
+	// no free slot in time.
+	e.GET("/b").Expect().Status(http.StatusServiceUnavailable)
+	close(release)
+	<-done
+	e.GET("/b").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+}
+
+func TestCacheShedOverload(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	cachedHandler := httpcache.Cache(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		res.Write([]byte(expectedBodyStr))
+	}), cacheDuration).MaxConcurrentOriginCalls(1, 0).ShedOverload(1500 * time.Millisecond)
+
+	e := httptest.New(t, httptest.Handler(cachedHandler))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.GET("/a").Expect().Status(http.StatusOK).Body().Equal(expectedBodyStr)
+	}()
+
+	<-started
+	// rejected right away, even without a wait timeout.
+	e.GET("/b").Expect().Status(http.StatusServiceUnavailable).Header("Retry-After").Equal("2")
+	close(release)
+	<-done
+}
@@ -0,0 +1,77 @@
+// Package iris provides a kataras/iris middleware which caches the responses
+// of the next handlers, it's the iris' equivalent of the httpcache.Cache.
+//
+// Example:
+//
+//	app := iris.New()
+//	app.Get("/", httpcacheiris.Cache(20*time.Second), func(ctx iris.Context) {
+//		ctx.WriteString("cached for 20 seconds")
+//	})
+//	app.Listen(":8080")
+package iris
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/geekypanda/httpcache/nethttp"
+	"github.com/geekypanda/httpcache/nethttp/rule"
+	kataras "github.com/kataras/iris/v12"
+)
+
+// responseWriter is the part of the iris' response writer
+// which lets the next handlers write to the cache's recorder.
+type responseWriter interface {
+	http.ResponseWriter
+	Naive() http.ResponseWriter
+	SetWriter(underline http.ResponseWriter)
+}
+
+// call is the iris' response writer of a request and its next handlers,
+// it's passed to the cached handler through the request's context.
+type call struct {
+	w    responseWriter
+	next http.HandlerFunc
+}
+
+// callKey is the request's context key of its call.
+type callKey struct{}
+
+// Cache returns an iris middleware which caches the next handlers' response,
+// one cache entry per request method, path and query,
+// it's a nethttp.Handler, converted by the iris.FromStd, so it follows the same rules as the httpcache.Cache.
+// The first parameter is, optional, the cache Entry's expiration duration
+// if the expiration <=2 seconds then expiration is taken by the "cache-control's maxage" header.
+//
+// On a cache hit the next handlers are not executed at all.
+//
+// Optional rules are executed after the nethttp.DefaultRuleSet,
+// use them to attach claim and valid predicates, i.e rule.Validator.
+func Cache(expiration time.Duration, rules ...rule.Rule) kataras.Handler {
+	h := nethttp.NewHandler(http.HandlerFunc(serveNext), expiration)
+	for _, r := range rules {
+		h.AddRule(r)
+	}
+
+	return kataras.FromStd(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		iw, ok := w.(responseWriter)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		// the cached responses are written to the underline writer,
+		// the iris' one writes to the handler's recorder, see serveNext.
+		h.ServeHTTP(iw.Naive(), r.WithContext(context.WithValue(r.Context(), callKey{}, &call{w: iw, next: next})))
+	})
+}
+
+// serveNext executes the next handlers of the request, their response is written to the "w" recorder.
+func serveNext(w http.ResponseWriter, r *http.Request) {
+	call := r.Context().Value(callKey{}).(*call)
+	underline := call.w.Naive()
+	call.w.SetWriter(w)
+	call.next(w, r)
+	call.w.SetWriter(underline)
+}
@@ -1,14 +1,21 @@
 package nethttp
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/geekypanda/httpcache/cfg"
+	"github.com/geekypanda/httpcache/entry"
 	"github.com/geekypanda/httpcache/nethttp/rule"
+	"github.com/geekypanda/httpcache/ruleset"
 	"github.com/geekypanda/httpcache/uri"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ClientHandler is the client-side handler
@@ -19,6 +26,13 @@ import (
 //  which lives on other, external machine.
 //
 type ClientHandler struct {
+	// unavailableUntil is the unix nanoseconds time until the remote cache server is not called,
+	// see Cooldown. It's accessed atomically, keep it first for the 64-bit alignment.
+	unavailableUntil int64
+	// disabled is 1 when the caching is disabled, see SetEnabled.
+	// It's accessed atomically.
+	disabled uint32
+
 	// bodyHandler the original route's handler
 	bodyHandler http.Handler
 
@@ -26,10 +40,81 @@ type ClientHandler struct {
 	//
 	// See more at ruleset.go
 	rule rule.Rule
+	// defaultRule and addedRules compose the rule,
+	// the defaultRule is executed first, see WithoutDefaultDeniers.
+	defaultRule rule.Rule
+	addedRules  []rule.Rule
 
 	life time.Duration
 
+	// notFoundLife is the lifetime of the cached 404 responses,
+	// a negative value means that the life is used.
+	//
+	// See NotFoundTTL.
+	notFoundLife time.Duration
+
 	remoteHandlerURL string
+
+	// queryParams and ignoredQueryParams are the query parameters
+	// which participate or not in the cache key, see CacheQueryParams and IgnoreQueryParams.
+	queryParams, ignoredQueryParams []string
+
+	// keyNormalizer is optional, if not nil then it normalizes the cache keys,
+	// see KeyNormalizer.
+	keyNormalizer func(key string) string
+
+	// keyByHost reports whether the request's host participates in the cache key,
+	// see KeyByHost.
+	keyByHost bool
+
+	// keyPrefix prefixes the keys of the remote cache server, see KeyPrefix.
+	keyPrefix string
+	// keyFunc is optional, if not nil then it returns the keys of the remote cache server,
+	// see KeyFunc.
+	keyFunc func(*http.Request) string
+
+	// expiration is optional, if not nil then it returns the lifetimes of the responses,
+	// see Expiration.
+	expiration entry.ExpirationFunc
+
+	// ttlPatterns are the lifetimes by request path pattern, see TTLByPattern.
+	ttlPatterns uri.TTLPatterns
+
+	// tracer is optional, if not nil then the original handler's executions
+	// are traced, see Tracer.
+	tracer trace.Tracer
+
+	// logger reports the cache hits, misses and the remote errors, defaults to the cfg.NopLogger,
+	// see Logger.
+	logger cfg.Logger
+
+	// maxBodySize is the maximum body's size, in bytes, of a cached response,
+	// zero means no limit, see MaxBodySize.
+	maxBodySize int
+
+	// workers is nil when the responses are stored synchronously,
+	// otherwise it limits the concurrent background stores, see StoreWorkers.
+	workers chan struct{}
+
+	// retries and backoff are the remote GET retries on network errors, see Retries.
+	retries int
+	backoff time.Duration
+	// cooldown is the duration which the remote cache server is not called after a network error,
+	// see Cooldown.
+	cooldown time.Duration
+	// storeErrorPolicy is the behavior when the remote cache server can't be reached,
+	// see OnStoreError.
+	storeErrorPolicy cfg.StoreErrorPolicy
+	// allowSetCookie reports whether the responses which set cookies are cached,
+	// see AllowSetCookie.
+	allowSetCookie bool
+
+	// remoteClient is the client of the remote cache server, nil means the Client,
+	// see RemoteClient.
+	remoteClient *http.Client
+	// remoteTimeout is the timeout of each request to the remote cache server,
+	// zero means the remote client's one, see RemoteTimeout.
+	remoteTimeout time.Duration
 }
 
 // NewClientHandler returns a new remote client handler
@@ -45,8 +130,11 @@ func NewClientHandler(bodyHandler http.Handler, life time.Duration, remote strin
 	return &ClientHandler{
 		bodyHandler:      bodyHandler,
 		rule:             DefaultRuleSet,
+		defaultRule:      DefaultRuleSet,
 		life:             life,
+		notFoundLife:     -1,
 		remoteHandlerURL: remote,
+		logger:           cfg.NopLogger,
 	}
 }
 
@@ -60,6 +148,8 @@ func (h *ClientHandler) Rule(r rule.Rule) *ClientHandler {
 		r = rule.Satisfied()
 	}
 	h.rule = r
+	h.defaultRule = r
+	h.addedRules = nil
 
 	return h
 }
@@ -72,7 +162,279 @@ func (h *ClientHandler) AddRule(r rule.Rule) *ClientHandler {
 		return h
 	}
 
-	h.rule = rule.Chained(h.rule, r)
+	h.addedRules = append(h.addedRules, r)
+	h.rule = rule.Chained(h.defaultRule, h.addedRules...)
+	return h
+}
+
+// WithDeniers adds deniers in the chain, the requests that at least one of them returns true for
+// are served by the original handler as they are, without the cache,
+// i.e the requests of a specific method or with a specific header.
+//
+// returns itself.
+func (h *ClientHandler) WithDeniers(deniers ...func(*http.Request) bool) *ClientHandler {
+	if len(deniers) == 0 {
+		return h
+	}
+
+	return h.AddRule(rule.Deny(deniers...))
+}
+
+// WithoutDefaultDeniers removes the DefaultRuleSet, or the one which is set by Rule, from this handler's chain,
+// the rules added by AddRule and WithDeniers are kept.
+// The DefaultRuleSet package variable is not modified, the rest of the handlers are not affected.
+//
+// returns itself.
+func (h *ClientHandler) WithoutDefaultDeniers() *ClientHandler {
+	h.defaultRule = rule.Satisfied()
+	h.rule = rule.Chained(h.defaultRule, h.addedRules...)
+	return h
+}
+
+// MaxBodySize sets the maximum body's size, in bytes, of a response which can be cached,
+// bigger responses are still streamed to the client but they are not recorded nor cached,
+// this way large downloads don't blow up the memory.
+// Zero means no limit, the default.
+//
+// returns itself.
+func (h *ClientHandler) MaxBodySize(n int) *ClientHandler {
+	if n < 0 {
+		n = 0
+	}
+	h.maxBodySize = n
+	return h
+}
+
+// StoreWorkers sets the maximum number of the concurrent background requests
+// which store the responses to the remote cache server,
+// when all of them are busy the next store waits for one of them to finish.
+// Zero means that the responses are stored synchronously,
+// before the handler returns, the default.
+//
+// It should be called before the handler starts serving.
+//
+// returns itself.
+func (h *ClientHandler) StoreWorkers(n int) *ClientHandler {
+	if n <= 0 {
+		h.workers = nil
+		return h
+	}
+	h.workers = make(chan struct{}, n)
+	return h
+}
+
+// Retries sets the number of the remote GET retries on network errors,
+// the "backoff" is the wait duration before the first retry, it doubles on each next one.
+// Defaults to zero retries.
+//
+// returns itself.
+func (h *ClientHandler) Retries(n int, backoff time.Duration) *ClientHandler {
+	if n < 0 {
+		n = 0
+	}
+	h.retries = n
+	h.backoff = backoff
+	return h
+}
+
+// OnStoreError sets the behavior of the handler when the remote cache server can't be reached,
+// i.e it's down or it's in its Cooldown, the cfg.FailOpen executes the original handler, the default,
+// the cfg.FailClosed responds with the cfg.StoreUnavailableStatus instead.
+// The failed stores of the responses are always fail-open, the responses are already served,
+// all of the errors are reported to the Logger.
+//
+// returns itself.
+func (h *ClientHandler) OnStoreError(policy cfg.StoreErrorPolicy) *ClientHandler {
+	h.storeErrorPolicy = policy
+	return h
+}
+
+// AllowSetCookie caches the responses which set cookies,
+// they are not cached by default, see SetCookieRule.
+//
+// returns itself.
+func (h *ClientHandler) AllowSetCookie() *ClientHandler {
+	h.allowSetCookie = true
+	return h
+}
+
+// RemoteClient sets the client which sends the requests to the remote cache server of this handler,
+// i.e with a custom Transport, instead of the shared Client package variable.
+// If "client" is nil then the Client is used instead, the default.
+//
+// returns itself.
+func (h *ClientHandler) RemoteClient(client *http.Client) *ClientHandler {
+	h.remoteClient = client
+	return h
+}
+
+// RemoteTimeout sets the timeout of each request to the remote cache server of this handler,
+// this way a slow remote cache server doesn't add the remote client's whole timeout, the cfg.RequestCacheTimeout by default,
+// to the latency of a fast endpoint. The Client is not modified, the rest of the handlers are not affected.
+// Zero means the remote client's timeout, the default.
+//
+// returns itself.
+func (h *ClientHandler) RemoteTimeout(d time.Duration) *ClientHandler {
+	if d < 0 {
+		d = 0
+	}
+	h.remoteTimeout = d
+	return h
+}
+
+// Cooldown sets the duration which the remote cache server is not called at all
+// after a network error, even after the retries, the original handler serves the requests instead,
+// this way a remote cache server's outage doesn't add latency to every request.
+// Defaults to zero, the remote cache server is always called.
+//
+// returns itself.
+func (h *ClientHandler) Cooldown(d time.Duration) *ClientHandler {
+	if d < 0 {
+		d = 0
+	}
+	h.cooldown = d
+	return h
+}
+
+// remoteUnavailable reports whether the remote cache server
+// should not be called because of a recent network error.
+func (h *ClientHandler) remoteUnavailable() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&h.unavailableUntil)
+}
+
+// markRemoteUnavailable marks the remote cache server as unavailable
+// for the cooldown duration, if any.
+func (h *ClientHandler) markRemoteUnavailable() {
+	if h.cooldown > 0 {
+		atomic.StoreInt64(&h.unavailableUntil, time.Now().Add(h.cooldown).UnixNano())
+	}
+}
+
+// Tracer sets an OpenTelemetry tracer which traces the original handler executions on cache misses
+// and the GET and POST round-trips to the remote cache server,
+// each span is annotated with the cache key, the cache hit and if the response was stored.
+// Defaults to nil, no tracing.
+//
+// returns itself.
+func (h *ClientHandler) Tracer(tracer trace.Tracer) *ClientHandler {
+	h.tracer = tracer
+	return h
+}
+
+// Logger sets the logger which reports the cache hits, misses and the remote cache server's errors,
+// i.e log.New(os.Stderr, "", log.LstdFlags).
+// If "logger" is nil then the cfg.NopLogger is used instead, the default.
+//
+// returns itself.
+func (h *ClientHandler) Logger(logger cfg.Logger) *ClientHandler {
+	if logger == nil {
+		logger = cfg.NopLogger
+	}
+	h.logger = logger
+	return h
+}
+
+// CacheQueryParams sets the only query parameters which participate in the cache key,
+// i.e CacheQueryParams("q", "page") caches the "/search?q=golang&page=2&utm_source=x"
+// and the "/search?q=golang&page=2" responses as one.
+// The rest of the parameters are still passed to the original handler.
+//
+// returns itself.
+func (h *ClientHandler) CacheQueryParams(include ...string) *ClientHandler {
+	h.queryParams = include
+	return h
+}
+
+// IgnoreQueryParams sets the query parameters which don't participate in the cache key,
+// i.e IgnoreQueryParams("utm_source", "utm_medium").
+// These parameters are still passed to the original handler.
+//
+// returns itself.
+func (h *ClientHandler) IgnoreQueryParams(exclude ...string) *ClientHandler {
+	h.ignoredQueryParams = exclude
+	return h
+}
+
+// KeyNormalizer sets a function which normalizes the cache key, the request's path and its query,
+// before the lookup and the store, this way the equivalent requests share the same cached response.
+// It's applied after the CacheQueryParams and IgnoreQueryParams filters.
+// See the uri.NormalizeKey builtin normalizer which lowercases the path and strips its trailing slashes.
+//
+// returns itself.
+func (h *ClientHandler) KeyNormalizer(normalizer func(key string) string) *ClientHandler {
+	h.keyNormalizer = normalizer
+	return h
+}
+
+// Expiration sets a function which returns the lifetime of each response based on its contents,
+// it's called after the original handler is executed, a zero lifetime means that the response is not cached.
+// It has priority over the rest of the lifetime options.
+//
+// returns itself.
+func (h *ClientHandler) Expiration(fn entry.ExpirationFunc) *ClientHandler {
+	h.expiration = fn
+	return h
+}
+
+// TTLByPattern sets the lifetimes of the responses by their request path pattern,
+// i.e {"/static/*": time.Hour, "/api/*": 30 * time.Second}, this way a handler (or a router) which serves many routes
+// declares its TTL policy in one place. The longest matching pattern wins, see uri.TTLPatterns.
+// The handler's expiration is used for the paths that no pattern matches.
+//
+// returns itself.
+func (h *ClientHandler) TTLByPattern(patterns map[string]time.Duration) *ClientHandler {
+	h.ttlPatterns = uri.TTLPatterns(patterns)
+	return h
+}
+
+// KeyByHost makes the request's host part of the cache key, i.e "a.example.com/articles",
+// this way a virtual-hosted, multi-tenant, server doesn't share the cached responses of the same paths between its hosts.
+//
+// returns itself.
+func (h *ClientHandler) KeyByHost() *ClientHandler {
+	h.keyByHost = true
+	return h
+}
+
+// KeyPrefix prefixes the keys of the cached responses on the remote cache server, i.e by the application's version "v2|",
+// this way a new deploy doesn't reuse the remote entries of the previous one, bumping the prefix invalidates them
+// without a flush of the remote cache server. It's applied on top of the KeyFunc too.
+//
+// returns itself.
+func (h *ClientHandler) KeyPrefix(prefix string) *ClientHandler {
+	h.keyPrefix = prefix
+	return h
+}
+
+// KeyFunc sets a function which returns the key of a request's cached response on the remote cache server,
+// without its method, i.e a canonical subset of its query.
+// It replaces the query filters, the key normalizer and the KeyByHost, the InvalidateRemote is not aware of its keys.
+//
+// returns itself.
+func (h *ClientHandler) KeyFunc(fn func(*http.Request) string) *ClientHandler {
+	h.keyFunc = fn
+	return h
+}
+
+// normalize returns the "key" normalized by the keyNormalizer, if any.
+func (h *ClientHandler) normalize(key string) string {
+	if h.keyNormalizer == nil {
+		return key
+	}
+	return h.keyNormalizer(key)
+}
+
+// NotFoundTTL sets the lifetime of the 404 responses,
+// it's useful when you want to cache the "not found" responses
+// for a shorter duration than the successful ones.
+// A zero duration means that 404 responses are not cached at all.
+//
+// returns itself.
+func (h *ClientHandler) NotFoundTTL(d time.Duration) *ClientHandler {
+	if d < 0 {
+		d = 0
+	}
+	h.notFoundLife = d
 	return h
 }
 
@@ -81,10 +443,35 @@ func (h *ClientHandler) AddRule(r rule.Rule) *ClientHandler {
 var Client = &http.Client{Timeout: cfg.RequestCacheTimeout}
 
 const (
-	methodGet  = "GET"
-	methodPost = "POST"
+	methodGet    = "GET"
+	methodPost   = "POST"
+	methodDelete = "DELETE"
 )
 
+// cacheKey returns the cache key of the "r" request, without its method,
+// it's the client uri which is sent to the remote cache server.
+func (h *ClientHandler) cacheKey(r *http.Request) string {
+	if h.keyFunc != nil {
+		return h.keyPrefix + h.keyFunc(r)
+	}
+
+	key := h.normalize(getCacheKey(r, h.queryParams, h.ignoredQueryParams))
+	if h.keyByHost {
+		key = r.Host + key
+	}
+	return h.keyPrefix + key
+}
+
+// serveUnavailable serves the "r" request when the remote cache server can't be reached,
+// by the original handler or by the cfg.StoreUnavailableStatus, see OnStoreError.
+func (h *ClientHandler) serveUnavailable(w http.ResponseWriter, r *http.Request) {
+	if h.storeErrorPolicy == cfg.FailClosed {
+		w.WriteHeader(cfg.StoreUnavailableStatus)
+		return
+	}
+	h.bodyHandler.ServeHTTP(w, r)
+}
+
 // ServeHTTP , or remote cache client whatever you like, it's the client-side function of the ServeHTTP
 // sends a request to the server-side remote cache Service and sends the cached response to the frontend client
 // it is used only when you achieved something like horizontal scaling (separate machines)
@@ -104,68 +491,279 @@ func (h *ClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// check for deniers, if at least one of them return true
 	// for this specific request, then skip the whole cache
-	if !h.rule.Claim(r) {
+	if !h.enabled() || bypassed(r) || !h.rule.Claim(r) {
 		h.bodyHandler.ServeHTTP(w, r)
 		return
 	}
+	if h.remoteUnavailable() {
+		h.serveUnavailable(w, r)
+		return
+	}
 
+	key := h.cacheKey(r)
 	uri := &uri.URIBuilder{}
-	uri.ServerAddr(h.remoteHandlerURL).ClientURI(r.URL.RequestURI()).ClientMethod(r.Method)
+	uri.ServerAddr(h.remoteHandlerURL).ClientURI(key).ClientMethod(getCacheMethod(r.Method))
 
 	// set the full url here because below we have other issues, probably net/http bugs
 	request, err := http.NewRequest(methodGet, uri.String(), nil)
 	if err != nil {
-		//// println("error when requesting to the remote service: " + err.Error())
+		h.logger.Printf("httpcache: remote get %s: %v", key, err)
 		// somehing very bad happens, just execute the user's handler and return
 		h.bodyHandler.ServeHTTP(w, r)
 		return
 	}
 
-	// println("GET Do to the remote cache service with the url: " + request.URL.String())
-	response, err := Client.Do(request)
+	// the retries of the remote get stop when the client goes away.
+	ctx, span := startSpan(h.tracer, r.Context(), cfg.RemoteGetSpanName, key)
+	request = request.WithContext(ctx)
+	response, err := h.get(request)
+	if err != nil && r.Context().Err() != nil {
+		// the client has gone away, not the remote cache server.
+		endSpan(span, false, false)
+		return
+	}
+	if err != nil {
+		h.logger.Printf("httpcache: remote get %s: %v", key, err)
+		h.markRemoteUnavailable()
+		if h.storeErrorPolicy == cfg.FailClosed {
+			endSpan(span, false, false)
+			h.serveUnavailable(w, r)
+			return
+		}
+	}
+	hit := err == nil && response.StatusCode != cfg.FailStatus
+	endSpan(span, hit, false)
+	if err == nil && !hit {
+		// the miss' body is not used, drain it so its connection can be reused.
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+	}
+
+	if !hit && r.Method == http.MethodHead {
+		// the HEAD responses are not cached,
+		// they are served by the cached GET responses only.
+		h.bodyHandler.ServeHTTP(w, r)
+		return
+	}
 
-	if err != nil || response.StatusCode == cfg.FailStatus {
+	if !hit {
+		h.logger.Printf("httpcache: miss %s", key)
 		// if not found on cache, then execute the handler and save the cache to the remote server
 		recorder := AcquireResponseRecorder(w)
 		defer ReleaseResponseRecorder(recorder)
+		recorder.SetMaxBodySize(h.maxBodySize)
 
+		ctx, span := startSpan(h.tracer, r.Context(), cfg.OriginSpanName, key)
+		if span != nil {
+			r = r.WithContext(ctx)
+		}
 		h.bodyHandler.ServeHTTP(recorder, r)
+		endSpan(span, false, false)
+
+		if err != nil {
+			// fail-open, the remote cache server is unavailable, don't wait for its post to fail too.
+			return
+		}
 
 		// check if it's a valid response, if it's not then just return.
 		if !h.rule.Valid(recorder, r) {
 			return
 		}
+		if !h.allowSetCookie && !SetCookieRule.Valid(recorder, r) {
+			return
+		}
+		if !NoCacheRule.Valid(recorder, r) {
+			return
+		}
+		if ruleset.CacheControlBehavior(recorder.Header().Get("Cache-Control"), ruleset.DefaultDirectives) == ruleset.SkipBehavior {
+			// i.e a "no-store" or a "private" response.
+			return
+		}
 		// save to the remote cache
 		// we re-create the request for any case
 
-		body := recorder.Body()[0:]
-		if len(body) == 0 {
-			//// println("Request: len body is zero, do nothing")
+		size := recorder.BodySize()
+		if size == 0 || recorder.Overflowed() || recorder.Streamed() {
+			return
+		}
+		statusCode := recorder.StatusCode()
+		if !validStatusCode(statusCode) {
+			// the handler wrote an invalid, i.e zero, or an informational status code.
 			return
 		}
-		uri.StatusCode(recorder.StatusCode())
-		uri.Lifetime(h.life)
+		life := h.life
+		if ttl, ok := h.ttlPatterns.Match(r.URL.Path); ok {
+			life = ttl
+		}
+		if h.expiration != nil {
+			if life = h.expiration(statusCode, recorder.ContentType(), recorder.Body()); life <= 0 {
+				return
+			}
+		} else if statusCode == http.StatusNotFound && h.notFoundLife >= 0 {
+			if h.notFoundLife == 0 {
+				// 404 responses should not be cached.
+				return
+			}
+			life = h.notFoundLife
+		} else if life < cfg.MinimumCacheDuration {
+			// the remote cache server falls back to the request's max age,
+			// the response's one has priority.
+			if maxAge := GetResponseMaxAge(recorder.Header(), r)(); maxAge > life {
+				life = maxAge
+			}
+		}
+
+		uri.StatusCode(statusCode)
+		uri.Lifetime(life)
 		uri.ContentType(recorder.ContentType())
 
-		request, err = http.NewRequest(methodPost, uri.String(), bytes.NewBuffer(body)) // yes new buffer every time
+		ctx = r.Context()
+		if h.workers != nil {
+			// the request's context is canceled after this handler returns,
+			// the background store needs its own.
+			ctx = trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+		}
 
-		// println("POST Do to the remote cache service with the url: " + request.URL.String())
+		// the recorded chunks are streamed as they are, the body is not copied again,
+		// they are kept by the reader even if the recorder is released.
+		request, err = http.NewRequest(methodPost, uri.String(), recorder.BodyReader())
 		if err != nil {
-			//// println("Request: error on method Post of request to the remote: " + err.Error())
+			h.logger.Printf("httpcache: remote post %s: %v", key, err)
+			return
+		}
+		// the http.NewRequest can't know the length of a custom reader.
+		request.ContentLength = int64(size)
+		ctx, span = startSpan(h.tracer, ctx, cfg.RemotePostSpanName, key)
+		request = request.WithContext(ctx)
+
+		if h.workers == nil {
+			h.post(request, span)
 			return
 		}
-		// go Client.Do(request)
-		Client.Do(request)
+
+		h.workers <- struct{}{}
+		go func() {
+			h.post(request, span)
+			<-h.workers
+		}()
 	} else {
+		h.logger.Printf("httpcache: hit %s", key)
 		// get the status code , content type and the write the response body
 		w.Header().Set(cfg.ContentTypeHeader, response.Header.Get(cfg.ContentTypeHeader))
-		w.WriteHeader(response.StatusCode)
 		responseBody, err := ioutil.ReadAll(response.Body)
 		response.Body.Close()
 		if err != nil {
+			w.WriteHeader(response.StatusCode)
 			return
 		}
-		w.Write(responseBody)
+		// the body's length is known, don't let the server chunk it.
+		w.Header().Set("Content-Length", strconv.Itoa(len(responseBody)))
+		w.WriteHeader(response.StatusCode)
+		if r.Method != http.MethodHead {
+			w.Write(responseBody)
+		}
+
+	}
+}
+
+// get sends the "request" which asks a response from the remote cache server,
+// it's retried on network errors until the request's context is done, see Retries.
+func (h *ClientHandler) get(request *http.Request) (*http.Response, error) {
+	response, err := h.do(request)
+	backoff := h.backoff
+	for i := 0; err != nil && i < h.retries; i++ {
+		select {
+		case <-time.After(backoff):
+		case <-request.Context().Done():
+			// the client has gone away, don't keep retrying.
+			return nil, request.Context().Err()
+		}
+		backoff *= 2
+		response, err = h.do(request)
+	}
+	return response, err
+}
 
+// do sends the "request" to the remote cache server
+// by the RemoteClient and with the RemoteTimeout, if any.
+func (h *ClientHandler) do(request *http.Request) (*http.Response, error) {
+	client := h.remoteClient
+	if client == nil {
+		client = Client
+	}
+	if h.remoteTimeout > 0 {
+		// a shallow copy, the client's Transport is shared.
+		c := *client
+		c.Timeout = h.remoteTimeout
+		client = &c
 	}
+	return client.Do(request)
+}
+
+// post sends the "request" which stores a response to the remote cache server.
+func (h *ClientHandler) post(request *http.Request, span trace.Span) {
+	stored := false
+	response, err := h.do(request)
+	if err == nil {
+		stored = response.StatusCode == cfg.SuccessStatus
+		response.Body.Close()
+	} else {
+		h.logger.Printf("httpcache: remote post %s: %v", request.URL, err)
+		h.markRemoteUnavailable()
+	}
+	endSpan(span, false, stored)
+}
+
+// ErrInvalidateFailed is returned by the InvalidateRemote
+// when the remote cache server responds with a fail status,
+// i.e the entry doesn't exist.
+var ErrInvalidateFailed = errors.New("httpcache: remote cache server failed to invalidate the entry")
+
+// InvalidateRemote removes the cached response of the "r" request
+// from the remote cache server of the "remoteServerAddr" address,
+// it's useful when the underline data of a response are changed.
+//
+// Note that the key is built by the whole query,
+// the CacheQueryParams and IgnoreQueryParams of the ClientHandler are not applied here.
+func InvalidateRemote(remoteServerAddr string, r *http.Request) error {
+	uri := &uri.URIBuilder{}
+	uri.ServerAddr(remoteServerAddr).ClientURI(getCacheKey(r, nil, nil)).ClientMethod(getCacheMethod(r.Method))
+
+	request, err := http.NewRequest(methodDelete, uri.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := Client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode == cfg.FailStatus {
+		return ErrInvalidateFailed
+	}
+	return nil
+}
+
+// SetEnabled enables or disables the caching of this handler at runtime,
+// a disabled handler executes the original handler, nothing is served from or stored to the cache,
+// the existing entries are kept, so it can be re-enabled instantly.
+// It's safe for concurrent use, see cfg.SetEnabled for all the handlers.
+//
+// The caching is enabled by default.
+//
+// returns itself.
+func (h *ClientHandler) SetEnabled(enabled bool) *ClientHandler {
+	var v uint32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&h.disabled, v)
+	return h
+}
+
+// enabled reports whether the caching is enabled for this handler and globally.
+func (h *ClientHandler) enabled() bool {
+	return cfg.Enabled() && atomic.LoadUint32(&h.disabled) == 0
 }
@@ -0,0 +1,27 @@
+package nethttp
+
+import (
+	"log"
+	"net/http"
+)
+
+// PanicHandler handles a panic of the original handler,
+// "err" is the recovered value, see Handler.OnPanic.
+type PanicHandler func(w http.ResponseWriter, r *http.Request, err interface{})
+
+// DefaultPanicHandler logs the panic and responds with a 500 status code.
+var DefaultPanicHandler PanicHandler = func(w http.ResponseWriter, r *http.Request, err interface{}) {
+	log.Printf("httpcache: recovered from a panic of the %s %s handler: %v", r.Method, r.URL.RequestURI(), err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// serveOrigin executes the "handler",
+// it returns the recovered value if the "handler" panics, otherwise nil.
+func serveOrigin(handler http.Handler, w http.ResponseWriter, r *http.Request) (err interface{}) {
+	defer func() {
+		err = recover()
+	}()
+
+	handler.ServeHTTP(w, r)
+	return nil
+}
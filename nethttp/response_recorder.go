@@ -1,12 +1,26 @@
 package nethttp
 
 import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
 	"net/http"
 	"sync"
+	"time"
+
+	"github.com/geekypanda/httpcache/entry"
 )
 
 var rpool = sync.Pool{}
 
+const (
+	// sniffLen is the maximum body's bytes which are used to detect its content type.
+	sniffLen = 512
+	// defaultContentType is the content type of the empty responses which have no content type.
+	defaultContentType = "text/plain; charset=utf-8"
+)
+
 // AcquireResponseRecorder returns a ResponseRecorder
 func AcquireResponseRecorder(underline http.ResponseWriter) *ResponseRecorder {
 	v := rpool.Get()
@@ -24,7 +38,14 @@ func AcquireResponseRecorder(underline http.ResponseWriter) *ResponseRecorder {
 func ReleaseResponseRecorder(res *ResponseRecorder) {
 	res.underline = nil
 	res.statusCode = 0
+	res.wroteHeader = false
 	res.chunks = res.chunks[0:0]
+	res.size = 0
+	res.maxSize = 0
+	res.overflowed = false
+	res.streamed = false
+	res.ttlHeader = ""
+	res.ttl = ""
 	rpool.Put(res)
 }
 
@@ -33,31 +54,138 @@ type ResponseRecorder struct {
 	underline  http.ResponseWriter
 	chunks     [][]byte // 2d because .Write can be called more than one time in the same handler and we want to cache all of them
 	statusCode int      // the saved status code which will be used from the cache service
+	// wroteHeader is true when the status code is written, explicitly or by the first Write,
+	// it distinguishes a status code which is not set from an explicit zero one.
+	wroteHeader bool
+
+	size       int  // the recorded body's size
+	maxSize    int  // the maximum recorded body's size, zero means no limit
+	overflowed bool // true when the written body's size exceeded the maxSize, then nothing is recorded
+	streamed   bool // true when the response is flushed or its connection is hijacked, then it's not cached
+
+	ttlHeader string // the response header which sets the lifetime of the cached response, see SetTTLHeader
+	ttl       string // the removed value of the ttlHeader
+}
+
+var (
+	_ http.Flusher  = (*ResponseRecorder)(nil)
+	_ http.Hijacker = (*ResponseRecorder)(nil)
+	_ http.Pusher   = (*ResponseRecorder)(nil)
+)
+
+// SetMaxBodySize sets the maximum body's size, in bytes, which can be recorded,
+// if the written contents exceed that size then the recorder stops recording
+// and drops the already recorded chunks, but the contents are still written
+// to the underline response writer.
+// Zero means no limit, the default.
+func (res *ResponseRecorder) SetMaxBodySize(n int) {
+	res.maxSize = n
+}
+
+// SetTTLHeader sets the response header which the handler can write to set the lifetime
+// of its cached response, i.e "X-Cache-TTL: 120", the header is removed before it's sent
+// to the client, see TTL.
+// Empty means disabled, the default.
+func (res *ResponseRecorder) SetTTLHeader(name string) {
+	res.ttlHeader = name
+}
+
+// TTL returns the lifetime which the handler has written to the TTL header, see SetTTLHeader,
+// returns false if it's absent or invalid.
+func (res *ResponseRecorder) TTL() (time.Duration, bool) {
+	res.removeTTLHeader()
+	return entry.ParseTTL(res.ttl)
+}
+
+// removeTTLHeader removes the TTL header from the response and keeps its value.
+func (res *ResponseRecorder) removeTTLHeader() {
+	if res.ttlHeader == "" {
+		return
+	}
+	if v := res.Header().Get(res.ttlHeader); v != "" {
+		res.ttl = v
+		res.Header().Del(res.ttlHeader)
+	}
+}
+
+// Overflowed returns true if the written contents exceeded
+// the maximum body's size, then the response should not be cached.
+func (res *ResponseRecorder) Overflowed() bool {
+	return res.overflowed
 }
 
 // Body joins the chunks to one []byte slice, this is the full body
 func (res *ResponseRecorder) Body() []byte {
-	var body []byte
+	// fast path, the handler wrote exactly once (the usual case of the small responses),
+	// the chunk is already a copy which is owned by the recorder, return it as it's.
+	if len(res.chunks) == 1 {
+		return res.chunks[0]
+	}
+	body := make([]byte, 0, res.size)
 	for i := range res.chunks {
 		body = append(body, res.chunks[i]...)
 	}
 	return body
 }
 
-// ContentType returns the header's value of "Content-Type"
+// BodySize returns the recorded body's size, in bytes.
+func (res *ResponseRecorder) BodySize() int {
+	return res.size
+}
+
+// BodyReader returns a reader of the recorded chunks, they are not joined,
+// the recorder can be released while the reader is in use.
+func (res *ResponseRecorder) BodyReader() io.Reader {
+	// the net.Buffers are consumed by the Read, use a copy of the chunks' slice.
+	chunks := net.Buffers(append([][]byte(nil), res.chunks...))
+	return &chunks
+}
+
+// ContentType returns the header's value of "Content-Type",
+// if the handler didn't set one then it's detected from the first 512 bytes
+// of the recorded body, as the http.ResponseWriter does, a "gzip" encoded body is decompressed first,
+// if there is no body then it's "text/plain; charset=utf-8".
 func (res *ResponseRecorder) ContentType() string {
-	return res.Header().Get("Content-Type")
+	if cType := res.Header().Get("Content-Type"); cType != "" {
+		return cType
+	}
+
+	var head []byte
+	for i := 0; i < len(res.chunks) && len(head) < sniffLen; i++ {
+		head = append(head, res.chunks[i]...)
+	}
+	if len(head) == 0 {
+		return defaultContentType
+	}
+	if res.Header().Get("Content-Encoding") == "gzip" {
+		// decompress only the first bytes, the chunks are read as far as they are needed.
+		if gr, err := gzip.NewReader(res.BodyReader()); err == nil {
+			if decoded, err := io.ReadAll(io.LimitReader(gr, sniffLen)); err == nil || len(decoded) > 0 {
+				head = decoded
+			}
+		}
+	}
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return http.DetectContentType(head)
 }
 
-// StatusCode returns the status code, if not given then returns 200
-// but doesn't changes the existing behavior
+// StatusCode returns the status code, if not given then returns 200,
+// as the http.ResponseWriter does,
+// otherwise the first written status code as it's, even if it's invalid, i.e zero.
 func (res *ResponseRecorder) StatusCode() int {
-	if res.statusCode == 0 {
+	if !res.wroteHeader {
 		return 200
 	}
 	return res.statusCode
 }
 
+// Written returns true if the status code or the body is written.
+func (res *ResponseRecorder) Written() bool {
+	return res.wroteHeader
+}
+
 // Header returns the header map that will be sent by
 // WriteHeader. Changing the header after a call to
 // WriteHeader (or Write) has no effect unless the modified
@@ -88,10 +216,20 @@ func (res *ResponseRecorder) Header() http.Header {
 // by all HTTP/2 clients. Handlers should read before writing if
 // possible to maximize compatibility.
 func (res *ResponseRecorder) Write(contents []byte) (int, error) {
-	if res.statusCode == 0 { // if not setted set it here
+	if !res.wroteHeader { // if not setted set it here
 		res.WriteHeader(http.StatusOK)
 	}
-	res.chunks = append(res.chunks, contents)
+	if !res.overflowed {
+		if res.maxSize > 0 && res.size+len(contents) > res.maxSize {
+			// stop recording but keep streaming to the client.
+			res.overflowed = true
+			res.chunks = res.chunks[0:0]
+		} else {
+			// the handler can reuse the "contents" after the Write, copy them.
+			res.chunks = append(res.chunks, append([]byte(nil), contents...))
+			res.size += len(contents)
+		}
+	}
 	return res.underline.Write(contents)
 }
 
@@ -100,10 +238,62 @@ func (res *ResponseRecorder) Write(contents []byte) (int, error) {
 // will trigger an implicit WriteHeader(http.StatusOK).
 // Thus explicit calls to WriteHeader are mainly used to
 // send error codes.
+//
+// The informational 1xx status codes, i.e the 103 Early Hints, are sent to the client
+// but they are not recorded, the handler writes its final status code after them.
 func (res *ResponseRecorder) WriteHeader(statusCode int) {
-	if res.statusCode == 0 { // set it only if not setted already, we don't want logs about multiple sends
+	if statusCode >= 100 && statusCode <= 199 && statusCode != http.StatusSwitchingProtocols {
+		if !res.wroteHeader {
+			res.removeTTLHeader()
+			res.underline.WriteHeader(statusCode)
+		}
+		return
+	}
+	if !res.wroteHeader { // set it only if not setted already, we don't want logs about multiple sends
+		res.wroteHeader = true
 		res.statusCode = statusCode
+		res.removeTTLHeader()
 		res.underline.WriteHeader(statusCode)
 	}
 
 }
+
+// Flush sends any buffered data to the client, if the underline http.ResponseWriter
+// is an http.Flusher, i.e of a server-sent events handler.
+// A flushed response is a streaming one, it's not cached, see Streamed.
+func (res *ResponseRecorder) Flush() {
+	res.streamed = true
+	if !res.wroteHeader {
+		res.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := res.underline.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if the underline http.ResponseWriter
+// is an http.Hijacker, i.e of a websocket handler, otherwise it returns an http.ErrNotSupported.
+// A hijacked response is not cached, see Streamed.
+func (res *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := res.underline.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	res.streamed = true
+	return hijacker.Hijack()
+}
+
+// Push initiates an HTTP/2 server push, if the underline http.ResponseWriter
+// is an http.Pusher, otherwise it returns an http.ErrNotSupported.
+func (res *ResponseRecorder) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := res.underline.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Streamed returns true if the response has been flushed or its connection has been hijacked,
+// then the response should not be cached.
+func (res *ResponseRecorder) Streamed() bool {
+	return res.streamed
+}
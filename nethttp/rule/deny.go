@@ -0,0 +1,31 @@
+package rule
+
+import (
+	"net/http"
+)
+
+// denyRule is a Rule which denies the requests that at least one of its deniers returns true for.
+type denyRule struct {
+	deniers []func(*http.Request) bool
+}
+
+var _ Rule = &denyRule{}
+
+// Deny returns a new rule which denies the requests that at least one of the "deniers" returns true for,
+// the original handler serves them as it's, without the cache.
+func Deny(deniers ...func(*http.Request) bool) Rule {
+	return &denyRule{deniers: deniers}
+}
+
+func (d *denyRule) Claim(r *http.Request) bool {
+	for _, deny := range d.deniers {
+		if deny(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *denyRule) Valid(http.ResponseWriter, *http.Request) bool {
+	return true
+}
@@ -1,6 +1,7 @@
 package nethttp
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/geekypanda/httpcache/cfg"
@@ -22,14 +23,45 @@ var DefaultRuleSet = rule.Chained(
 	// server.
 	rule.HeaderClaim(ruleset.MustRevalidateRule),
 	rule.HeaderClaim(ruleset.ZeroMaxAgeRule),
+	// the legacy HTTP/1.0 clients force a fresh response with a "Pragma: no-cache" header,
+	// the original handler serves them.
+	rule.HeaderClaim(ruleset.PragmaNoCacheRule),
 	// #3 custom No-Cache header used inside this library
-	// for BOTH request and response (after get-cache action)
-	rule.Header(ruleset.NoCacheRule, ruleset.NoCacheRule),
+	// for BOTH request and response (after get-cache action),
+	// the responses are checked by the NoCacheRule too, whatever the rules are.
+	rule.Header(ruleset.NoCacheRule, ruleset.NoCacheResponseRule),
 )
 
+// SetCookieRule is the post-cache validator which denies the responses that set cookies,
+// it's executed by ALL handlers, local and remote, unless their AllowSetCookie is called.
+var SetCookieRule = rule.HeaderValid(ruleset.SetCookieRule)
+
+// NoCacheRule is the post-cache validator which denies the responses that are marked by the NoCache,
+// it's executed by ALL handlers, local and remote, even if their rules don't include the DefaultRuleSet.
+var NoCacheRule = rule.HeaderValid(ruleset.NoCacheResponseRule)
+
 // NoCache called when a particular handler is not valid for cache.
 // If this function called inside a handler then the handler is not cached
 // even if it's surrounded with the Cache/CacheFunc wrappers.
 func NoCache(w http.ResponseWriter) {
 	w.Header().Set(cfg.NoCacheHeader, "true")
 }
+
+type bypassKey struct{}
+
+// BypassKey is the request's context key which, with a true value, makes the handlers
+// to execute the original handler, the response is not served from the cache neither stored,
+// i.e of a preview mode which is decided by a middleware before the cache. See WithBypass.
+var BypassKey interface{} = bypassKey{}
+
+// WithBypass returns a copy of the "ctx" which makes the handlers to bypass the cache,
+// i.e next.ServeHTTP(w, r.WithContext(WithBypass(r.Context()))), see BypassKey.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, BypassKey, true)
+}
+
+// bypassed reports whether the "r" request should bypass the cache, see BypassKey.
+func bypassed(r *http.Request) bool {
+	v, _ := r.Context().Value(BypassKey).(bool)
+	return v
+}
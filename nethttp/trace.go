@@ -0,0 +1,31 @@
+package nethttp
+
+import (
+	"context"
+
+	"github.com/geekypanda/httpcache/cfg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts a new span of the "tracer" as a child of the "ctx" span, if any,
+// annotated with the cache key.
+// It returns a nil span if the "tracer" is nil, this way there is no overhead when tracing is disabled.
+func startSpan(tracer trace.Tracer, ctx context.Context, name string, key string) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String(cfg.SpanKeyAttribute, key)))
+}
+
+// endSpan annotates the "span" with the hit and stored results and ends it,
+// if the "span" is nil then it does nothing.
+func endSpan(span trace.Span, hit bool, stored bool) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttributes(attribute.Bool(cfg.SpanHitAttribute, hit), attribute.Bool(cfg.SpanStoredAttribute, stored))
+	span.End()
+}
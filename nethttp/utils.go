@@ -2,9 +2,12 @@ package nethttp
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/geekypanda/httpcache/entry"
+	"github.com/geekypanda/httpcache/uri"
 )
 
 // GetMaxAge parses the "Cache-Control" header
@@ -18,3 +21,135 @@ func GetMaxAge(r *http.Request) entry.LifeChanger {
 		return time.Duration(headerCacheDur) * time.Second
 	}
 }
+
+// GetResponseMaxAge parses the response's "Cache-Control" "header"
+// and returns a LifeChanger which can be passed
+// to the response's Reset,
+// if the response has no max age then its "Expires" header is used instead, max-age wins over it,
+// and if it has neither of them then the request's max age is used, see GetMaxAge.
+func GetResponseMaxAge(header http.Header, r *http.Request) entry.LifeChanger {
+	return func() time.Duration {
+		if seconds := entry.ParseMaxAge(header.Get("Cache-Control")); seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if expires, ok := entry.ParseExpires(header.Get("Expires")); ok && expires > 0 {
+			return expires
+		}
+		return GetMaxAge(r)()
+	}
+}
+
+// expiredResponse reports whether the response of the "header" has no max age
+// and its "Expires" header is a past date, then it's already expired.
+func expiredResponse(header http.Header) bool {
+	if entry.ParseMaxAge(header.Get("Cache-Control")) >= 0 {
+		return false
+	}
+	expires, ok := entry.ParseExpires(header.Get("Expires"))
+	return ok && expires <= 0
+}
+
+// getCacheMethod returns the request method which participates in the cache key,
+// the HEAD requests share the cached responses of the GET ones.
+func getCacheMethod(method string) string {
+	if method == http.MethodHead {
+		return http.MethodGet
+	}
+	return method
+}
+
+// getRequestURIKey returns the cache key of a request uri, i.e "/articles?page=2",
+// see getCacheKey.
+func getRequestURIKey(requestURI string, include, exclude []string) string {
+	key, query := requestURI, ""
+	if i := strings.IndexByte(requestURI, '?'); i >= 0 {
+		key, query = requestURI[:i], requestURI[i+1:]
+	}
+	if query = uri.FilterQuery(query, include, exclude); query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// statusVariantKey returns the cache key of the "statusCode" variant of the "key",
+// which may be the key of another variant already, see Handler.KeyByStatus.
+func statusVariantKey(key string, statusCode int) string {
+	if i := strings.LastIndex(key, "#status="); i >= 0 {
+		key = key[:i]
+	}
+	return key + "#status=" + strconv.Itoa(statusCode)
+}
+
+// containsMethod reports whether the "method" is one of the "methods".
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// getCacheKey returns the cache key of a request,
+// its path and its query filtered by the "include" and "exclude" parameters,
+// see uri.FilterQuery.
+func getCacheKey(r *http.Request, include, exclude []string) string {
+	key := r.URL.EscapedPath()
+	if query := uri.FilterQuery(r.URL.RawQuery, include, exclude); query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// setHeader adds the stored headers of the "res" response to the "header",
+// each value as a separate header, see Handler.CacheCookies and Handler.CacheHeaders.
+// The headers which are already set, i.e by the cache itself, are not replaced.
+func setHeader(header http.Header, res *entry.Response) {
+	for k, values := range res.Header() {
+		if _, exists := header[k]; exists {
+			continue
+		}
+		for _, v := range values {
+			header.Add(k, v)
+		}
+	}
+}
+
+// validStatusCode reports whether the "statusCode" is a valid, three-digit, status code of a final response,
+// the informational 1xx ones are never cached.
+func validStatusCode(statusCode int) bool {
+	return statusCode >= 200 && statusCode <= 999
+}
+
+// isRedirect reports whether the "statusCode" is a redirect one, which is cached with its "Location" header.
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// setValidators sets the "ETag" and the "Last-Modified" headers of the "res" response, if any.
+func setValidators(header http.Header, res *entry.Response) {
+	if etag := res.ETag(); etag != "" {
+		header.Set("ETag", etag)
+	}
+	if lastModified := res.LastModified(); lastModified != "" {
+		header.Set("Last-Modified", lastModified)
+	}
+}
+
+// defaultGCInterval returns the expired entries scan's interval of the default memory store
+// of a handler with the "life" expiration, it's the "life" duration,
+// or a minute if the lifetimes are taken by the headers, see Handler.GCInterval.
+func defaultGCInterval(life time.Duration) time.Duration {
+	if life <= 0 {
+		return time.Minute
+	}
+	if life < time.Second {
+		return time.Second
+	}
+	return life
+}
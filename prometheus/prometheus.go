@@ -0,0 +1,63 @@
+// Package prometheus provides a prometheus.Collector which reports
+// the counters of a remote cache service's Handler, see server.Handler.Stats.
+//
+// Usage:
+//
+//	handler := server.NewHandler(nil)
+//	prometheus.MustRegister(httpcacheprometheus.NewCollector(handler))
+package prometheus
+
+import (
+	"github.com/geekypanda/httpcache/server"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace is the prefix of the metrics' names.
+var Namespace = "httpcache"
+
+// Collector is a prometheus.Collector which reports the hits, the misses,
+// the entries, the bytes and the evictions of a server.Handler,
+// it keeps no state, the values are read from the handler's Stats on each scrape.
+type Collector struct {
+	handler *server.Handler
+
+	hits, misses, entries, bytes, evictions *prom.Desc
+}
+
+var _ prom.Collector = (*Collector)(nil)
+
+// NewCollector returns a new Collector of the "handler",
+// register it to a prometheus registry.
+func NewCollector(handler *server.Handler) *Collector {
+	return &Collector{
+		handler:   handler,
+		hits:      newDesc("hits_total", "The number of the cache hits."),
+		misses:    newDesc("misses_total", "The number of the cache misses."),
+		entries:   newDesc("entries", "The number of the valid cache entries."),
+		bytes:     newDesc("bytes", "The total size of the valid cache entries' bodies, in bytes."),
+		evictions: newDesc("evictions_total", "The number of the cache entries that the store has removed by itself."),
+	}
+}
+
+func newDesc(name string, help string) *prom.Desc {
+	return prom.NewDesc(prom.BuildFQName(Namespace, "", name), help, nil, nil)
+}
+
+// Describe sends the descriptors of the metrics, it implements the prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.entries
+	ch <- c.bytes
+	ch <- c.evictions
+}
+
+// Collect sends the current values of the metrics, it implements the prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	stats := c.handler.Stats()
+	ch <- prom.MustNewConstMetric(c.hits, prom.CounterValue, float64(stats.Hits))
+	ch <- prom.MustNewConstMetric(c.misses, prom.CounterValue, float64(stats.Misses))
+	ch <- prom.MustNewConstMetric(c.entries, prom.GaugeValue, float64(stats.Entries))
+	ch <- prom.MustNewConstMetric(c.bytes, prom.GaugeValue, float64(stats.Bytes))
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.Evictions))
+}
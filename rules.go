@@ -0,0 +1,173 @@
+package httpcache
+
+import (
+	"net/http"
+	"regexp"
+
+	fhttprule "github.com/geekypanda/httpcache/fhttp/rule"
+	"github.com/geekypanda/httpcache/nethttp/rule"
+	"github.com/geekypanda/httpcache/ruleset"
+	"github.com/valyala/fasthttp"
+)
+
+// RuleSet is a builder of a rule for both the net/http and the fasthttp handlers,
+// its conditions should all be true for a request and its response to be cached, see Rules.
+type RuleSet struct {
+	methods  []string
+	statuses []int
+	claims   []ruleset.HeaderPredicate
+	valids   []ruleset.HeaderPredicate
+}
+
+// Rules returns a new, empty, RuleSet,
+// i.e httpcache.Cache(handler, time.Minute, httpcache.Rules().Status(200, 404).Method("GET")).
+func Rules() *RuleSet {
+	return new(RuleSet)
+}
+
+// ClaimWhen adds a predicate of the request headers,
+// the requests which don't satisfy it bypass the cache.
+//
+// returns itself.
+func (s *RuleSet) ClaimWhen(claim ruleset.HeaderPredicate) *RuleSet {
+	s.claims = append(s.claims, claim)
+	return s
+}
+
+// ValidWhen adds a predicate of the response headers,
+// the responses which don't satisfy it are not cached.
+//
+// returns itself.
+func (s *RuleSet) ValidWhen(valid ruleset.HeaderPredicate) *RuleSet {
+	s.valids = append(s.valids, valid)
+	return s
+}
+
+// Method sets the only request methods which are cached, i.e "GET",
+// the requests of the rest of the methods bypass the cache.
+//
+// returns itself.
+func (s *RuleSet) Method(methods ...string) *RuleSet {
+	s.methods = append(s.methods, methods...)
+	return s
+}
+
+// Status sets the only response status codes which are cached, i.e 200 and 404.
+//
+// returns itself.
+func (s *RuleSet) Status(statusCodes ...int) *RuleSet {
+	s.statuses = append(s.statuses, statusCodes...)
+	return s
+}
+
+// Header caches only the responses that their "name" header's value matches the "valueRegex",
+// see ValidWhenHeader. It panics if the "valueRegex" is not a valid regular expression.
+//
+// returns itself.
+func (s *RuleSet) Header(name string, valueRegex string) *RuleSet {
+	return s.ValidWhen(ruleset.HeaderMatches(name, regexp.MustCompile(valueRegex)))
+}
+
+// Rule returns the net/http rule of the set's current conditions,
+// i.e httpcache.Cache(handler, time.Minute).AddRule(httpcache.Rules().Status(200).Rule()).
+func (s *RuleSet) Rule() rule.Rule {
+	return &netRule{s.clone()}
+}
+
+// FasthttpRule returns the fasthttp rule of the set's current conditions.
+func (s *RuleSet) FasthttpRule() fhttprule.Rule {
+	return &fasthttpRule{s.clone()}
+}
+
+// clone returns a copy of the set, its rules don't change after they are built.
+func (s *RuleSet) clone() RuleSet {
+	return RuleSet{
+		methods:  append([]string(nil), s.methods...),
+		statuses: append([]int(nil), s.statuses...),
+		claims:   append([]ruleset.HeaderPredicate(nil), s.claims...),
+		valids:   append([]ruleset.HeaderPredicate(nil), s.valids...),
+	}
+}
+
+// claim reports whether the request of the "method" and its headers satisfy the set.
+func (s *RuleSet) claim(method string, header ruleset.GetHeader) bool {
+	if len(s.methods) > 0 && !containsString(s.methods, method) {
+		return false
+	}
+	for _, claim := range s.claims {
+		if !claim(header) {
+			return false
+		}
+	}
+	return true
+}
+
+// valid reports whether the response of the "statusCode" and its headers satisfy the set.
+func (s *RuleSet) valid(statusCode int, header ruleset.GetHeader) bool {
+	if len(s.statuses) > 0 && !containsInt(s.statuses, statusCode) {
+		return false
+	}
+	for _, valid := range s.valids {
+		if !valid(header) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// netRule is the net/http rule of a RuleSet.
+type netRule struct {
+	set RuleSet
+}
+
+var _ rule.Rule = &netRule{}
+
+func (r *netRule) Claim(req *http.Request) bool {
+	return r.set.claim(req.Method, req.Header.Get)
+}
+
+func (r *netRule) Valid(w http.ResponseWriter, req *http.Request) bool {
+	// the handlers validate their recorded responses.
+	statusCode := http.StatusOK
+	if recorder, ok := w.(interface{ StatusCode() int }); ok {
+		statusCode = recorder.StatusCode()
+	}
+	return r.set.valid(statusCode, w.Header().Get)
+}
+
+// fasthttpRule is the fasthttp rule of a RuleSet.
+type fasthttpRule struct {
+	set RuleSet
+}
+
+var _ fhttprule.Rule = &fasthttpRule{}
+
+func (r *fasthttpRule) Claim(reqCtx *fasthttp.RequestCtx) bool {
+	return r.set.claim(string(reqCtx.Method()), func(key string) string {
+		return string(reqCtx.Request.Header.Peek(key))
+	})
+}
+
+func (r *fasthttpRule) Valid(reqCtx *fasthttp.RequestCtx) bool {
+	return r.set.valid(reqCtx.Response.StatusCode(), func(key string) string {
+		return string(reqCtx.Response.Header.Peek(key))
+	})
+}
@@ -1,6 +1,14 @@
 // Package ruleset provides the basics rules which are being extended bynethttp's and fhttp's rules.
 package ruleset
 
+import (
+	"regexp"
+	"strings"
+
+	"github.com/geekypanda/httpcache/cfg"
+	"github.com/geekypanda/httpcache/entry"
+)
+
 // The shared header-mostly rules for both nethttp and fasthttp
 var (
 	AuthorizationRule = func(header GetHeader) bool {
@@ -20,6 +28,33 @@ var (
 	NoCacheRule = func(header GetHeader) bool {
 		return header("No-Cache") != "true"
 	}
+
+	// NoCacheResponseRule denies the responses which are marked as not cacheable
+	// by the NoCache functions, see cfg.NoCacheHeader.
+	NoCacheResponseRule = func(header GetHeader) bool {
+		return header(cfg.NoCacheHeader) != "true" && header("No-Cache") != "true"
+	}
+
+	// PragmaNoCacheRule denies the requests of the legacy clients and proxies
+	// which force a fresh response with a "Pragma: no-cache" header.
+	PragmaNoCacheRule = func(header GetHeader) bool {
+		return !strings.Contains(strings.ToLower(header("Pragma")), "no-cache")
+	}
+
+	// CacheControlRule decides the cacheability of a response purely by its "Cache-Control" directives, RFC 7234,
+	// it's false if its CacheControlBehavior of the DefaultDirectives is the SkipBehavior:
+	// the "no-store" and the "private" responses are not stored by a shared cache, neither the ones with a zero
+	// "s-maxage" or "max-age", unless they are "no-cache" ones which are revalidated. The rest are stored.
+	// The handlers evaluate the directives by their own map instead, see their Directive.
+	CacheControlRule = func(header GetHeader) bool {
+		return CacheControlBehavior(header("Cache-Control"), DefaultDirectives) != SkipBehavior
+	}
+
+	// SetCookieRule denies the responses which set cookies,
+	// a user's cookie must not be sent to the rest of the users of a shared cache.
+	SetCookieRule = func(header GetHeader) bool {
+		return header("Set-Cookie") == ""
+	}
 )
 
 // THESE ARE HERE BECAUSE THE GOLANG DOESN'T SUPPORTS THE F....  INTERFACE ALIAS, THIS SHOULD EXISTS ONLY ON /$package/rule
@@ -41,3 +76,78 @@ type HeaderPredicate func(GetHeader) bool
 var EmptyHeaderPredicate = func(GetHeader) bool {
 	return true
 }
+
+// HeaderMatches returns a header predicate which is true
+// when the "name" header's value matches the "valueExp", i.e regexp.MustCompile("^public").
+func HeaderMatches(name string, valueExp *regexp.Regexp) HeaderPredicate {
+	return func(header GetHeader) bool {
+		return valueExp.MatchString(header(name))
+	}
+}
+
+// Behavior is the way that the cache handlers treat a response
+// based on its "Cache-Control" header's directives, see DefaultDirectives.
+type Behavior uint8
+
+const (
+	// StoreBehavior stores the response, it's the behavior of all non-listed directives.
+	StoreBehavior Behavior = iota
+	// RevalidateBehavior stores the response but it must be revalidated
+	// by the original handler before each reuse,
+	// the original handler can short-circuit it with a 304 status code
+	// because the request contains the stored "ETag" and "Last-Modified" as
+	// "If-None-Match" and "If-Modified-Since" headers.
+	RevalidateBehavior
+	// SkipBehavior doesn't store the response at all.
+	SkipBehavior
+)
+
+// ZeroMaxAgeDirective is the pseudo directive of the responses with a zero "s-maxage" or "max-age",
+// which are stale at once, see CacheControlBehavior. It can be mapped to a Behavior like the real ones.
+const ZeroMaxAgeDirective = "max-age=0"
+
+// DefaultDirectives maps the response's "Cache-Control" directives to their Behavior,
+// used by the nethttp and fhttp handlers, each handler can change its own map.
+// The "no-store" and the "private" responses are not stored by a shared cache, RFC 7234,
+// the "no-cache" ones are stored but they are revalidated.
+var DefaultDirectives = map[string]Behavior{
+	"no-cache":          RevalidateBehavior,
+	"no-store":          SkipBehavior,
+	"private":           SkipBehavior,
+	ZeroMaxAgeDirective: SkipBehavior,
+}
+
+// DirectiveBehavior returns the strictest Behavior of the "cacheControl" header's directives
+// based on the "directives" map.
+func DirectiveBehavior(cacheControl string, directives map[string]Behavior) Behavior {
+	b := StoreBehavior
+	if cacheControl == "" || len(directives) == 0 {
+		return b
+	}
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		if idx := strings.IndexByte(directive, '='); idx != -1 {
+			directive = directive[0:idx]
+		}
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if db, ok := directives[directive]; ok && db > b {
+			b = db
+		}
+	}
+
+	return b
+}
+
+// CacheControlBehavior returns the Behavior of a response by its "cacheControl" header,
+// the DirectiveBehavior of its directives based on the "directives" map, and a response
+// with a zero "s-maxage" or "max-age" is treated as the ZeroMaxAgeDirective, unless one of its directives
+// already makes it revalidated, i.e the common "no-cache, max-age=0" with an "ETag".
+func CacheControlBehavior(cacheControl string, directives map[string]Behavior) Behavior {
+	b := DirectiveBehavior(cacheControl, directives)
+	if b == StoreBehavior && entry.ParseMaxAge(cacheControl) == 0 {
+		if zb, ok := directives[ZeroMaxAgeDirective]; ok {
+			b = zb
+		}
+	}
+	return b
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"time"
+
+	"github.com/geekypanda/httpcache/entry"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket name which the boltStore keeps its entries.
+var boltBucket = []byte("httpcache")
+
+// boltStore keeps the cache entries, serialized, inside an embedded bolt database file,
+// useful for single-binary deployments which need a persistent cache
+// without any external services.
+type boltStore struct {
+	hooks
+	db    *bolt.DB
+	codec entry.Codec
+	stop  chan struct{}
+}
+
+// NewBoltStore opens or creates the bolt database file of the "path"
+// and returns a new store which keeps its entries there.
+// "gcDuration" is the interval of the background scan which removes the expired entries,
+// a value <=0 disables the scan.
+//
+// The returned Store implements the io.Closer too, which stops the scan and closes the database file.
+func NewBoltStore(path string, gcDuration time.Duration) (Store, error) {
+	return NewBoltStoreWithCodec(path, gcDuration, entry.DefaultCodec)
+}
+
+// NewBoltStoreWithCodec same as NewBoltStore but the entries are encoded by the "codec",
+// i.e entry.JSONCodec{} or a custom one which compresses them.
+// If "codec" is nil then the entry.DefaultCodec is used instead.
+//
+// Note that a database file should be always opened with the same codec.
+func NewBoltStoreWithCodec(path string, gcDuration time.Duration, codec entry.Codec) (Store, error) {
+	if codec == nil {
+		codec = entry.DefaultCodec
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &boltStore{
+		db:    db,
+		codec: codec,
+		stop:  make(chan struct{}),
+	}
+
+	if gcDuration > 0 {
+		go s.startGC(gcDuration)
+	}
+
+	return s, nil
+}
+
+func (s *boltStore) Set(key string, statusCode int, contentType string, body []byte, expiration time.Duration) {
+	e := entry.NewEntry(expiration)
+	e.Reset(statusCode, contentType, body, nil)
+	s.SetEntry(key, e)
+}
+
+func (s *boltStore) SetEntry(key string, e *entry.Entry) {
+	s.TrySetEntry(key, e)
+}
+
+func (s *boltStore) TrySetEntry(key string, e *entry.Entry) error {
+	data, err := s.codec.Encode(e)
+	if err != nil {
+		return err
+	}
+
+	// batch the concurrent writes into one transaction.
+	err = s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+	if err != nil {
+		return err
+	}
+	s.fireSet(key, e)
+	return nil
+}
+
+// SwapEntry replaces the "old" entry of the key, see Swapper.
+// The entries are decoded on each Get, the stored one is the "old" one if it expires at the same time.
+func (s *boltStore) SwapEntry(key string, old, e *entry.Entry) bool {
+	data, err := s.codec.Encode(e)
+	if err != nil {
+		return false
+	}
+
+	swapped := false
+	s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		stored := b.Get([]byte(key))
+		if stored == nil {
+			return nil
+		}
+		current, err := s.codec.Decode(stored)
+		if err != nil || !current.ExpiresAt().Equal(old.ExpiresAt()) {
+			// replaced in between.
+			return nil
+		}
+		if err = b.Put([]byte(key), data); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped
+}
+
+// SetMulti adds, or replaces, the "entries" with a single transaction.
+func (s *boltStore) SetMulti(entries map[string]*entry.Entry) {
+	encoded := make(map[string][]byte, len(entries))
+	for key, e := range entries {
+		data, err := s.codec.Encode(e)
+		if err != nil {
+			continue
+		}
+		encoded[key] = data
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for key, data := range encoded {
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for key := range encoded {
+		s.fireSet(key, entries[key])
+	}
+}
+
+// GetMulti returns the existing entries of the "keys" with a single transaction.
+func (s *boltStore) GetMulti(keys []string) map[string]*entry.Entry {
+	entries := make(map[string]*entry.Entry, len(keys))
+	s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, key := range keys {
+			data := b.Get([]byte(key))
+			if data == nil {
+				continue
+			}
+
+			// data is valid only inside the transaction,
+			// the decoder copies it.
+			if e, err := s.codec.Decode(data); err == nil {
+				entries[key] = e
+			}
+		}
+		return nil
+	})
+	return entries
+}
+
+func (s *boltStore) Get(key string) *entry.Entry {
+	var e *entry.Entry
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		// data is valid only inside the transaction,
+		// the decoder copies it.
+		v, err := s.codec.Decode(data)
+		if err != nil {
+			return err
+		}
+		e = v
+		return nil
+	})
+
+	return e
+}
+
+func (s *boltStore) Remove(key string) {
+	s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) RemoveMatching(match func(key string) bool) int {
+	n := 0
+	s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		var matched [][]byte
+		b.ForEach(func(k, _ []byte) error {
+			if match(string(k)) {
+				matched = append(matched, k)
+			}
+			return nil
+		})
+
+		for _, k := range matched {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func (s *boltStore) Len() int {
+	n := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (s *boltStore) Keys() []string {
+	var keys []string
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys
+}
+
+// Close stops the expired entries scan and closes the database file.
+func (s *boltStore) Close() error {
+	close(s.stop)
+	return s.db.Close()
+}
+
+func (s *boltStore) startGC(gcDuration time.Duration) {
+	timer := time.NewTimer(gcJitter(gcDuration))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+			s.removeExpired()
+			timer.Reset(gcJitter(gcDuration))
+		}
+	}
+}
+
+// ClearExpired removes the expired or the non-decodable entries right now,
+// except the ones which can still be served as stale, returns the number of the removed entries.
+func (s *boltStore) ClearExpired() int {
+	return s.removeExpired()
+}
+
+// removeExpired removes the expired or the non-decodable entries,
+// except the ones which can still be served as stale.
+func (s *boltStore) removeExpired() int {
+	evicted := make(map[string]*entry.Entry)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		var expired [][]byte
+		b.ForEach(func(k, data []byte) error {
+			e, err := s.codec.Decode(data)
+			if err == nil {
+				if _, valid := e.Response(); valid {
+					return nil
+				}
+				if _, stale := e.Stale(); stale {
+					return nil
+				}
+			}
+			// keys are valid only inside the transaction, we are still there.
+			expired = append(expired, k)
+			evicted[string(k)] = e
+			return nil
+		})
+
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	s.fireEvict(evicted)
+	return len(evicted)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/geekypanda/httpcache/cfg"
+)
+
+// debugEntry is the JSON form of a stored entry's metadata, see DebugHandler.
+type debugEntry struct {
+	Key         string  `json:"key"`
+	StatusCode  int     `json:"statusCode"`
+	ContentType string  `json:"contentType"`
+	Size        int     `json:"size"`
+	Age         float64 `json:"age"`
+	TTL         float64 `json:"ttl"`
+}
+
+// authorized reports whether the request sends the "token" as "Authorization: Bearer <token>".
+func authorized(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// DebugHandler returns a handler which responds with the metadata of the valid entries as JSON,
+// their key, status code, content type, body size, age and remaining time to live, in seconds.
+// It's safe to serve it under concurrent traffic.
+//
+// If the handler's Config has a DebugToken then the requests should send it
+// as "Authorization: Bearer <DebugToken>", otherwise they are rejected with a 401 status code.
+func (s *Handler) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := s.config.DebugToken; token != "" && !authorized(r, token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		keys := s.store.Keys()
+		sort.Strings(keys)
+
+		now := time.Now()
+		entries := make([]debugEntry, 0, len(keys))
+		for _, key := range keys {
+			e := Peek(s.store, key)
+			if e == nil {
+				continue
+			}
+			res, valid := e.Response()
+			if !valid {
+				continue
+			}
+
+			ttl := e.ExpiresAt().Sub(now)
+			entries = append(entries, debugEntry{
+				Key:         key,
+				StatusCode:  res.StatusCode(),
+				ContentType: res.ContentType(),
+				Size:        len(res.Body()),
+				Age:         (e.LifeTime() - ttl).Seconds(),
+				TTL:         ttl.Seconds(),
+			})
+		}
+
+		w.Header().Set(cfg.ContentTypeHeader, "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(entries)
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/geekypanda/httpcache/cfg"
+	"github.com/geekypanda/httpcache/entry"
+)
+
+// EntryCallback is the callback of a store's entry event, see Notifier.
+type EntryCallback func(key string, e *entry.Entry)
+
+// Notifier is an optional interface of a Store
+// which fires callbacks on its entries' events,
+// useful to keep an external system coherent with the cache, i.e a secondary index.
+// All of the builtin stores implement it.
+//
+// The callbacks run without holding the store's lock,
+// so they can call the store's methods.
+type Notifier interface {
+	// OnSet sets the callback which fires after an entry is stored.
+	OnSet(cb EntryCallback)
+	// OnEvict sets the callback which fires after an entry is removed by the store itself,
+	// i.e it's expired or it's evicted to make room for a new one,
+	// the manual removals don't fire it.
+	// The entry is nil if the store couldn't decode it.
+	OnEvict(cb EntryCallback)
+}
+
+// EvictionCounter is an optional interface of a Store
+// which counts the entries that it has removed by itself, see Notifier.OnEvict.
+// All of the builtin stores implement it, it's used by the Handler.Stats.
+type EvictionCounter interface {
+	// Evictions returns the number of the evicted entries since the store's creation.
+	Evictions() uint64
+}
+
+// hooks keeps the Notifier callbacks and the evictions counter of a store,
+// it's embedded to the builtin stores.
+type hooks struct {
+	// evictions is accessed atomically, keep it first for the 64-bit alignment.
+	evictions      uint64
+	onSet, onEvict EntryCallback
+	mu             sync.RWMutex
+}
+
+func (h *hooks) OnSet(cb EntryCallback) {
+	h.mu.Lock()
+	h.onSet = cb
+	h.mu.Unlock()
+}
+
+func (h *hooks) OnEvict(cb EntryCallback) {
+	h.mu.Lock()
+	h.onEvict = cb
+	h.mu.Unlock()
+}
+
+func (h *hooks) Evictions() uint64 {
+	return atomic.LoadUint64(&h.evictions)
+}
+
+// fireSet fires the OnSet callback, if any.
+func (h *hooks) fireSet(key string, e *entry.Entry) {
+	h.mu.RLock()
+	cb := h.onSet
+	h.mu.RUnlock()
+	if cb != nil {
+		cb(key, e)
+	}
+}
+
+// fireEvict fires the OnEvict callback, if any, for each of the "evicted" entries.
+func (h *hooks) fireEvict(evicted map[string]*entry.Entry) {
+	if len(evicted) == 0 {
+		return
+	}
+
+	atomic.AddUint64(&h.evictions, uint64(len(evicted)))
+
+	h.mu.RLock()
+	cb := h.onEvict
+	h.mu.RUnlock()
+	if cb == nil {
+		return
+	}
+
+	for k, e := range evicted {
+		cb(k, e)
+	}
+}
+
+// LogEvictions reports the evictions of the "store", if it's a Notifier, to the "logger",
+// it replaces the store's OnEvict callback.
+func LogEvictions(store Store, logger cfg.Logger) {
+	n, ok := store.(Notifier)
+	if !ok || logger == nil {
+		return
+	}
+
+	n.OnEvict(func(key string, _ *entry.Entry) {
+		logger.Printf("httpcache: evicted %s", key)
+	})
+}
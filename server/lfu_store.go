@@ -0,0 +1,338 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/geekypanda/httpcache/entry"
+)
+
+type (
+	// lfuItem is an entry of the lfuStore with its access frequency.
+	lfuItem struct {
+		entry     *entry.Entry
+		frequency uint64
+		// size is the entry's body size as it's stored.
+		size int
+		// order is the insertion order of the entry,
+		// the oldest one is evicted first between the entries of the same frequency.
+		order uint64
+	}
+
+	// lfuStore is a memory store which keeps up to a maximum number of entries,
+	// when it's full the least frequently used entry is evicted to make room for the new one.
+	lfuStore struct {
+		hooks
+		cache      map[string]*lfuItem
+		maxEntries int
+		// bytes is the total size of the entries' bodies, see ByteCounter.
+		bytes int64
+		// highBytes and lowBytes are the watermarks of the bytes, see NewMemoryStoreLFUWithWatermarks.
+		highBytes, lowBytes int64
+		// inserted is the number of the inserted entries, see lfuItem.order.
+		inserted uint64
+		mu       sync.Mutex
+		// gcStop stops the running scan, if any, see SetGCInterval.
+		gcStop chan struct{}
+	}
+)
+
+// NewMemoryStoreLFU returns a new memory store which keeps up to "maxEntries" entries,
+// when it's full the least frequently used entry is evicted, an entry's frequency is increased
+// on each Get, this way the entries which are hot for a long time survive the bursts of one-off requests.
+// Between the entries of the same frequency the oldest one is evicted.
+// A "maxEntries" <=0 means no limit.
+//
+// "gcDuration" is the interval of the background scan which removes the expired entries
+// and halves the frequencies of the rest, so the once-popular entries don't stick forever,
+// a value <=0 disables the scan.
+//
+// The returned Store implements the GarbageCollector, which changes the scan's interval,
+// and the io.Closer too, which stops the scan.
+func NewMemoryStoreLFU(gcDuration time.Duration, maxEntries int) Store {
+	return NewMemoryStoreLFUWithWatermarks(gcDuration, maxEntries, 0, 0)
+}
+
+// NewMemoryStoreLFUWithWatermarks same as NewMemoryStoreLFU but it bounds the total size
+// of the entries' bodies too, when a Set pushes it above the "highBytes" watermark
+// the expired entries and then the least frequently used ones are evicted until it's below the "lowBytes" watermark,
+// this way the memory pressure is relieved at once and the next Sets don't evict one entry each,
+// the new entry is always stored, it's never rejected.
+// A "highBytes" <=0 means no limit, a "lowBytes" <=0 or bigger than the "highBytes" means the "highBytes".
+//
+// The returned Store implements the ByteCounter, which reports the total size.
+func NewMemoryStoreLFUWithWatermarks(gcDuration time.Duration, maxEntries int, highBytes, lowBytes int64) Store {
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
+	if highBytes < 0 {
+		highBytes = 0
+	}
+	if lowBytes <= 0 || lowBytes > highBytes {
+		lowBytes = highBytes
+	}
+
+	s := &lfuStore{
+		cache:      make(map[string]*lfuItem),
+		maxEntries: maxEntries,
+		highBytes:  highBytes,
+		lowBytes:   lowBytes,
+	}
+
+	s.SetGCInterval(gcDuration)
+	return s
+}
+
+func (s *lfuStore) Set(key string, statusCode int, contentType string, body []byte, expiration time.Duration) {
+	e := entry.NewEntry(expiration)
+	e.Reset(statusCode, contentType, body, nil)
+	s.SetEntry(key, e)
+}
+
+// set adds or replaces the entry of the key, an entry is evicted if the store is full
+// and the entries are evicted down to the low watermark if the high one is exceeded,
+// returns the evicted entries.
+func (s *lfuStore) set(key string, e *entry.Entry) map[string]*entry.Entry {
+	var evicted map[string]*entry.Entry
+	size := e.Size()
+
+	if item, ok := s.cache[key]; ok {
+		// keep the frequency of a renewed entry.
+		s.bytes += int64(size - item.size)
+		item.entry = e
+		item.size = size
+	} else {
+		if s.maxEntries > 0 && len(s.cache) >= s.maxEntries {
+			if k, v, ok := s.evict(key); ok {
+				evicted = map[string]*entry.Entry{k: v}
+			}
+		}
+		s.inserted++
+		s.cache[key] = &lfuItem{entry: e, size: size, order: s.inserted}
+		s.bytes += int64(size)
+	}
+
+	if s.highBytes > 0 && s.bytes > s.highBytes {
+		for s.bytes > s.lowBytes {
+			k, v, ok := s.evict(key)
+			if !ok {
+				break
+			}
+			if evicted == nil {
+				evicted = make(map[string]*entry.Entry)
+			}
+			evicted[k] = v
+		}
+	}
+
+	return evicted
+}
+
+// evict removes an expired entry, if any, otherwise the least frequently used one,
+// the oldest one if many of them have the same frequency,
+// the entry of the "except" key is never removed, returns the removed entry.
+func (s *lfuStore) evict(except string) (string, *entry.Entry, bool) {
+	var (
+		victim string
+		min    uint64
+		order  uint64
+		found  bool
+	)
+
+	for k, item := range s.cache {
+		if k == except {
+			continue
+		}
+
+		if _, valid := item.entry.Response(); !valid {
+			victim = k
+			found = true
+			break
+		}
+
+		if !found || item.frequency < min || (item.frequency == min && item.order < order) {
+			victim = k
+			min = item.frequency
+			order = item.order
+			found = true
+		}
+	}
+
+	if !found {
+		return "", nil, false
+	}
+
+	e := s.cache[victim].entry
+	s.delete(victim)
+	return victim, e, true
+}
+
+// delete removes the entry of the key and forgets its size.
+func (s *lfuStore) delete(key string) {
+	if item, ok := s.cache[key]; ok {
+		s.bytes -= int64(item.size)
+		delete(s.cache, key)
+	}
+}
+
+func (s *lfuStore) SetEntry(key string, e *entry.Entry) {
+	s.mu.Lock()
+	evicted := s.set(key, e)
+	s.mu.Unlock()
+	s.fireEvict(evicted)
+	s.fireSet(key, e)
+}
+
+// SwapEntry replaces the "old" entry of the key, its frequency is kept, see Swapper.
+func (s *lfuStore) SwapEntry(key string, old, e *entry.Entry) bool {
+	s.mu.Lock()
+	item, ok := s.cache[key]
+	swapped := ok && item.entry == old
+	if swapped {
+		s.bytes += int64(e.Size() - item.size)
+		item.entry = e
+		item.size = e.Size()
+	}
+	s.mu.Unlock()
+	return swapped
+}
+
+func (s *lfuStore) Get(key string) *entry.Entry {
+	s.mu.Lock()
+	if item, ok := s.cache[key]; ok {
+		item.frequency++
+		s.mu.Unlock()
+		return item.entry
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Peek returns the entry of the key without increasing its frequency, see Peeker.
+func (s *lfuStore) Peek(key string) *entry.Entry {
+	s.mu.Lock()
+	if item, ok := s.cache[key]; ok {
+		s.mu.Unlock()
+		return item.entry
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *lfuStore) Remove(key string) {
+	s.mu.Lock()
+	s.delete(key)
+	s.mu.Unlock()
+}
+
+func (s *lfuStore) RemoveMatching(match func(key string) bool) int {
+	n := 0
+	s.mu.Lock()
+	for k := range s.cache {
+		if match(k) {
+			s.delete(k)
+			n++
+		}
+	}
+	s.mu.Unlock()
+	return n
+}
+
+func (s *lfuStore) Len() int {
+	s.mu.Lock()
+	n := len(s.cache)
+	s.mu.Unlock()
+	return n
+}
+
+// Bytes returns the total size, in bytes, of the entries' bodies, see ByteCounter.
+func (s *lfuStore) Bytes() int64 {
+	s.mu.Lock()
+	n := s.bytes
+	s.mu.Unlock()
+	return n
+}
+
+func (s *lfuStore) Keys() []string {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.cache))
+	for k := range s.cache {
+		keys = append(keys, k)
+	}
+	s.mu.Unlock()
+	return keys
+}
+
+// SetGCInterval sets the interval of the background scan, a value <=0 disables it.
+func (s *lfuStore) SetGCInterval(d time.Duration) {
+	s.mu.Lock()
+	if s.gcStop != nil {
+		close(s.gcStop)
+		s.gcStop = nil
+	}
+	if d > 0 {
+		s.gcStop = make(chan struct{})
+		go s.startGC(d, s.gcStop)
+	}
+	s.mu.Unlock()
+}
+
+// Close stops the expired entries scan.
+func (s *lfuStore) Close() error {
+	s.SetGCInterval(0)
+	return nil
+}
+
+func (s *lfuStore) startGC(gcDuration time.Duration, stop chan struct{}) {
+	timer := time.NewTimer(gcJitter(gcDuration))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+			s.decay()
+			timer.Reset(gcJitter(gcDuration))
+		}
+	}
+}
+
+// ClearExpired removes the expired entries right now, except the ones which can still be served as stale,
+// the frequencies of the rest are kept, returns the number of the removed entries.
+func (s *lfuStore) ClearExpired() int {
+	evicted := make(map[string]*entry.Entry)
+	s.mu.Lock()
+	for k, item := range s.cache {
+		if _, valid := item.entry.Response(); valid {
+			continue
+		}
+		if _, stale := item.entry.Stale(); stale {
+			continue
+		}
+		evicted[k] = item.entry
+		s.delete(k)
+	}
+	s.mu.Unlock()
+	s.fireEvict(evicted)
+	return len(evicted)
+}
+
+// decay removes the expired entries, except the ones which can still be served as stale,
+// and halves the frequencies of the rest.
+func (s *lfuStore) decay() {
+	evicted := make(map[string]*entry.Entry)
+	s.mu.Lock()
+	for k, item := range s.cache {
+		if _, valid := item.entry.Response(); !valid {
+			if _, stale := item.entry.Stale(); !stale {
+				evicted[k] = item.entry
+				s.delete(k)
+				continue
+			}
+		}
+		item.frequency /= 2
+	}
+	s.mu.Unlock()
+	s.fireEvict(evicted)
+}
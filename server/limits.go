@@ -0,0 +1,147 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/geekypanda/httpcache/cfg"
+)
+
+// Config is the remote cache service's configuration,
+// it bounds the memory which the clients can consume, see NewHandlerWithConfig.
+// A zero Config means no limits.
+type Config struct {
+	// MaxBodySize is the maximum body's size, in bytes, of an entry,
+	// bigger POSTs are rejected with the cfg.FailStatus.
+	// Zero means no limit.
+	MaxBodySize int
+	// MaxEntries is the maximum number of the entries,
+	// a POST of a new entry when the store is full is rejected with the cfg.FailStatus.
+	// Zero means no limit.
+	MaxEntries int
+	// MaxBytes is the maximum total size, in bytes, of the entries' bodies,
+	// a POST which exceeds it is rejected with the cfg.FailStatus.
+	// Zero means no limit.
+	MaxBytes int64
+	// MinimumDuration is the lower bound of the entries' durations,
+	// the durations which are sent by the clients are honored as they are, unless they are shorter.
+	// Zero means the DefaultMinimumDuration.
+	//
+	// Note that the stores which don't implement the EntrySetter
+	// bound them by the cfg.MinimumCacheDuration instead.
+	MinimumDuration time.Duration
+	// PurgeToken enables the PURGE requests, which remove all the entries or the ones under a prefix,
+	// the requests should send it as "Authorization: Bearer <PurgeToken>".
+	// Empty means that the PURGE requests are rejected.
+	PurgeToken string
+	// DebugToken protects the Handler.DebugHandler, if not empty,
+	// the requests should send it as "Authorization: Bearer <DebugToken>".
+	DebugToken string
+	// Logger reports the clients' requests, the hits, the misses, the stored entries and the rejected ones.
+	// Nil means the cfg.NopLogger.
+	Logger cfg.Logger
+}
+
+// DefaultMinimumDuration is the default lower bound of the remote entries' durations,
+// see Config.MinimumDuration.
+var DefaultMinimumDuration = time.Second
+
+// minimumDuration returns the lower bound of the entries' durations.
+func (c Config) minimumDuration() time.Duration {
+	if c.MinimumDuration <= 0 {
+		return DefaultMinimumDuration
+	}
+	return c.MinimumDuration
+}
+
+// logger returns the Logger of the handler.
+func (c Config) logger() cfg.Logger {
+	if c.Logger == nil {
+		return cfg.NopLogger
+	}
+	return c.Logger
+}
+
+// limited reports whether the entries or the total bytes are limited.
+func (c Config) limited() bool {
+	return c.MaxEntries > 0 || c.MaxBytes > 0
+}
+
+// exceeds reports whether the "entries" and the "bytes" exceed the limits.
+func (c Config) exceeds(entries int, bytes int64) bool {
+	return (c.MaxEntries > 0 && entries > c.MaxEntries) || (c.MaxBytes > 0 && bytes > c.MaxBytes)
+}
+
+// reserve reserves the room of a "size" bytes body for the "key" entry,
+// the expired entries are removed to make room if needed.
+// Returns false if there is no room for it, then it should not be stored.
+func (s *Handler) reserve(key string, size int) bool {
+	if !s.config.limited() {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.sizes[key]
+	entries := len(s.sizes)
+	if !exists {
+		entries++
+	}
+	total := s.total - int64(old) + int64(size)
+
+	if s.config.exceeds(entries, total) {
+		s.removeExpired()
+		old, exists = s.sizes[key]
+		entries = len(s.sizes)
+		if !exists {
+			entries++
+		}
+		total = s.total - int64(old) + int64(size)
+		if s.config.exceeds(entries, total) {
+			return false
+		}
+	}
+
+	s.sizes[key] = size
+	s.total = total
+	return true
+}
+
+// release forgets the reserved room of the entries that their keys are matching.
+func (s *Handler) release(match func(key string) bool) {
+	if !s.config.limited() {
+		return
+	}
+
+	s.mu.Lock()
+	for k, size := range s.sizes {
+		if match(k) {
+			delete(s.sizes, k)
+			s.total -= int64(size)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// removeExpired removes the expired entries from the store and forgets their room,
+// the entries which have been removed by the store itself are forgotten too.
+func (s *Handler) removeExpired() {
+	for k, size := range s.sizes {
+		if e := Peek(s.store, k); e != nil {
+			if _, valid := e.Response(); valid {
+				continue
+			}
+			s.store.Remove(k)
+		}
+		delete(s.sizes, k)
+		s.total -= int64(size)
+	}
+}
+
+// releasePrefix is the release of the entries that their keys are starting with the "prefix".
+func (s *Handler) releasePrefix(prefix string) {
+	s.release(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
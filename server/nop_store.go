@@ -0,0 +1,35 @@
+package server
+
+import (
+	"time"
+
+	"github.com/geekypanda/httpcache/entry"
+)
+
+// nopStore is a store which never caches, see NewNopStore.
+type nopStore struct{}
+
+// NewNopStore returns a new store which never caches, its Get always returns nil
+// and its Set is a no-op, this way each request of a handler which uses it is a cache miss
+// and the original handler is always executed.
+// It's useful to test the handlers' behavior without the cache and without any timing.
+func NewNopStore() Store {
+	return nopStore{}
+}
+
+func (nopStore) Set(key string, statusCode int, contentType string, body []byte, expiration time.Duration) {
+}
+
+func (nopStore) Get(key string) *entry.Entry {
+	return nil
+}
+
+func (nopStore) Remove(key string) {}
+
+func (nopStore) Len() int {
+	return 0
+}
+
+func (nopStore) Keys() []string {
+	return nil
+}
@@ -11,6 +11,8 @@ Methods & actions:
   POST: Save a cache entry with its status content, content type
     and body, to the cache key-value store
   DELETE: Remove/Invalidate a cache entry based on its key
+  PURGE: Remove all the cache entries, or the ones under a key prefix,
+    it's allowed only if the Config has a PurgeToken
 
 
 A remote entry should have a unique key.
@@ -29,13 +31,18 @@ because remote service cache is based on http requests coming from the client si
 package server
 
 import (
+	"bytes"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/geekypanda/httpcache/cfg"
-	"github.com/geekypanda/httpcache/nethttp"
+	"github.com/geekypanda/httpcache/entry"
 )
 
 func getURLParam(r *http.Request, key string) string {
@@ -53,6 +60,7 @@ func getURLParamInt64(r *http.Request, key string) (int64, error) {
 const (
 	methodGet    = "GET"
 	methodPost   = "POST"
+	methodPurge  = "PURGE"
 	methodDelete = "DELETE"
 )
 
@@ -67,7 +75,166 @@ const (
 // yes, you're able to have more than one cache service
 // in the same http server
 type Handler struct {
-	store Store
+	// hits and misses are the GET requests of the clients which found, or not, a valid entry,
+	// see Stats. They are accessed atomically, keep them first for the 64-bit alignment.
+	hits, misses uint64
+
+	store  Store
+	config Config
+
+	// sizes are the body sizes of the stored entries, by key,
+	// they are tracked only when the config limits the entries or the total bytes.
+	sizes map[string]int
+	total int64
+	mu    sync.Mutex
+}
+
+// NewHandler returns a new remote cache service's Handler
+// which keeps its entries to the "store",
+// if "store" is nil then a memory store is used instead.
+func NewHandler(store Store) *Handler {
+	return NewHandlerWithConfig(store, Config{})
+}
+
+// NewHandlerWithConfig same as NewHandler but it bounds the memory
+// which the clients can consume, see Config.
+func NewHandlerWithConfig(store Store, c Config) *Handler {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Handler{
+		store:  store,
+		config: c,
+		sizes:  make(map[string]int),
+	}
+}
+
+// Store returns the store which keeps the entries of this handler,
+// i.e to inspect it or to share it with the local handlers.
+// Note that the entries which are set directly to it bypass the Config's limits.
+func (s *Handler) Store() Store {
+	return s.store
+}
+
+// InvalidatePrefix removes all the entries that their keys are starting with the "prefix",
+// i.e all the cached responses under a path.
+// Note that the prefix is matched against the raw cache key,
+// which is the client's request method + "http://" + request uri, i.e "GEThttp:///api/v1/users/".
+//
+// An empty "prefix" removes all the entries.
+//
+// Returns the number of the removed entries.
+func (s *Handler) InvalidatePrefix(prefix string) int {
+	match := func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+
+	n := 0
+	if r, ok := s.store.(MatchingRemover); ok {
+		n = r.RemoveMatching(match)
+	} else {
+		for _, key := range s.store.Keys() {
+			if match(key) {
+				s.store.Remove(key)
+				n++
+			}
+		}
+	}
+
+	s.releasePrefix(prefix)
+	return n
+}
+
+// Preload adds, or replaces, an entry to the store, without a client's request,
+// it's useful to warm up the cache at startup, i.e the popular pages after a deploy.
+// The "key" is the raw cache key, see InvalidatePrefix,
+// a "ttl" <=0 means the cfg.MinimumCacheDuration.
+//
+// It's safe to call it concurrently with the live traffic,
+// as long as the store is safe for concurrent use, all of the builtin stores are.
+//
+// Returns false if the entry exceeds the limits of the handler's Config, then it's not stored.
+func (s *Handler) Preload(key string, statusCode int, cType string, body []byte, ttl time.Duration) bool {
+	if s.config.MaxBodySize > 0 && len(body) > s.config.MaxBodySize {
+		return false
+	}
+	if !s.reserve(key, len(body)) {
+		return false
+	}
+
+	if ttl <= 0 {
+		ttl = cfg.MinimumCacheDuration
+	}
+	s.set(key, statusCode, cType, body, ttl)
+	return true
+}
+
+// set adds, or replaces, the entry of the key to the store,
+// its "ttl" is honored as it's, unless it's shorter than the Config.MinimumDuration.
+func (s *Handler) set(key string, statusCode int, cType string, body []byte, ttl time.Duration) {
+	setter, ok := s.store.(EntrySetter)
+	if !ok {
+		s.store.Set(key, statusCode, cType, body, ttl)
+		return
+	}
+
+	e := entry.NewEntryMinimum(ttl, s.config.minimumDuration())
+	e.Reset(statusCode, cType, body, nil)
+	setter.SetEntry(key, e)
+}
+
+// PreloadHandler runs the "handler" against a synthetic request of the "method" and the "requestURI",
+// i.e "GET" and "/api/v1/users?page=1", and adds its response to the store,
+// under the same key a client handler would use for this request.
+// See Preload too.
+//
+// Returns false if the response has an empty body, which is not cached,
+// or if it exceeds the limits of the handler's Config.
+func (s *Handler) PreloadHandler(method string, requestURI string, handler http.Handler, ttl time.Duration) bool {
+	r, err := http.NewRequest(method, requestURI, nil)
+	if err != nil {
+		return false
+	}
+	w := &preloadWriter{header: make(http.Header)}
+	handler.ServeHTTP(w, r)
+
+	body := w.body.Bytes()
+	if len(body) == 0 {
+		return false
+	}
+
+	// the client handlers send the method + "http://" + the request uri as key.
+	key := method + "http://" + requestURI
+	return s.Preload(key, w.statusCode, w.header.Get(cfg.ContentTypeHeader), body, ttl)
+}
+
+// preloadWriter is the http.ResponseWriter of the PreloadHandler,
+// it keeps the response in memory.
+type preloadWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *preloadWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *preloadWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *preloadWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	if w.body.Len() == 0 && w.header.Get(cfg.ContentTypeHeader) == "" {
+		// like a server does.
+		w.header.Set(cfg.ContentTypeHeader, http.DetectContentType(p))
+	}
+	return w.body.Write(p)
 }
 
 // ServeHTTP serves the cache Service to the outside world,
@@ -75,18 +242,26 @@ type Handler struct {
 // it parses the request and tries to return the response with the cached body of the requested cache key
 // server-side function
 func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// println("Request to the remote service has been established")
+	if r.Method == methodPurge {
+		s.purge(w, r)
+		return
+	}
+
 	key := getURLParam(r, cfg.QueryCacheKey)
 	if key == "" {
-		// println("return because key was empty")
+		s.config.logger().Printf("httpcache: %s request without a key", r.Method)
 		w.WriteHeader(cfg.FailStatus)
 		return
 	}
 
 	// we always need the Entry, so get it now
-	entry := s.store.Get(key)
+	e := s.store.Get(key)
 
-	if entry == nil && r.Method != methodPost {
+	if e == nil && r.Method != methodPost {
+		if r.Method == methodGet {
+			atomic.AddUint64(&s.misses, 1)
+			s.config.logger().Printf("httpcache: miss %s", key)
+		}
 		// if it's nil then means it never setted before
 		// it doesn't exists, and client doesn't wants to
 		// add a cache entry, so just return
@@ -102,28 +277,45 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case methodGet:
 		{
 			// get from the cache and send to client
-			res, ok := entry.Response()
+			res, ok := e.Response()
 			if !ok {
 				// entry exists but it has been expired
 				// return
+				atomic.AddUint64(&s.misses, 1)
+				s.config.logger().Printf("httpcache: miss %s", key)
 				w.WriteHeader(cfg.FailStatus)
 				return
 			}
 
 			// entry exists and response is valid
 			// send it to the client
+			atomic.AddUint64(&s.hits, 1)
+			s.config.logger().Printf("httpcache: hit %s", key)
 			w.Header().Set(cfg.ContentTypeHeader, res.ContentType())
 			w.WriteHeader(res.StatusCode())
 			w.Write(res.Body())
 		}
 	case methodPost:
 		{
-			// save a new cache entry if entry ==nil or
-			// update an existing if entry !=nil
+			// save a new cache entry or
+			// replace an existing one
+
+			var reqBody io.Reader = r.Body
+			if s.config.MaxBodySize > 0 {
+				// read one more byte to know if it's too big, without reading all of it.
+				reqBody = io.LimitReader(r.Body, int64(s.config.MaxBodySize)+1)
+			}
 
-			body, err := ioutil.ReadAll(r.Body)
+			body, err := ioutil.ReadAll(reqBody)
 			if err != nil || len(body) == 0 {
-				// println("body's request was empty, return fail")
+				s.config.logger().Printf("httpcache: rejected %s: empty body", key)
+				w.WriteHeader(cfg.FailStatus)
+				return
+			}
+
+			if (s.config.MaxBodySize > 0 && len(body) > s.config.MaxBodySize) || !s.reserve(key, len(body)) {
+				// too big body or no room for it.
+				s.config.logger().Printf("httpcache: rejected %s: %d bytes body exceeds the limits", key, len(body))
 				w.WriteHeader(cfg.FailStatus)
 				return
 			}
@@ -132,36 +324,30 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			contentType := getURLParam(r, cfg.QueryCacheContentType)
 
 			// now that we have the information
-			// we want to see if this is a totally new cache entry
-			// or just update an existing one with the new information
+			// we save a totally new cache entry, even if an entry exists already,
+			// this way the stores which keep their entries outside of the memory are updated too
 			// (an update can change the status code, content type
 			//     and ofcourse the body and expiration time by header)
 
-			if entry == nil {
-				// get the information by its url
-				// println("we have a post request method, let's save a cached entry ")
-				// get the cache expiration via url param
-				expirationSeconds, err := getURLParamInt64(r, cfg.QueryCacheDuration)
-				// get the body from the requested body
-				// get the expiration from the "cache-control's maxage" if no url param is setted
-				if expirationSeconds <= 0 || err != nil {
-					expirationSeconds = int64(nethttp.GetMaxAge(r)().Seconds())
-				}
-				// if not setted then try to get it via
-				if expirationSeconds <= 0 {
-					expirationSeconds = int64(cfg.MinimumCacheDuration.Seconds())
-				}
-
-				cacheDuration := time.Duration(expirationSeconds) * time.Second
-
-				// store by its url+the key in order to be unique key among different servers with the same paths
-				s.store.Set(key, statusCode, contentType, body, cacheDuration)
-			} else {
-				// update an existing one and change its duration  based on the header
-				// (if > existing duration)
-				entry.Reset(statusCode, contentType, body, nethttp.GetMaxAge(r))
+			// get the information by its url
+			// get the cache expiration via url param
+			expirationSeconds, err := getURLParamInt64(r, cfg.QueryCacheDuration)
+			// get the body from the requested body
+			// get the expiration from the "cache-control's maxage" if no url param is setted
+			if expirationSeconds <= 0 || err != nil {
+				expirationSeconds = entry.ParseMaxAge(r.Header.Get("Cache-Control"))
+			}
+			// if not setted then try to get it via
+			if expirationSeconds <= 0 {
+				expirationSeconds = int64(cfg.MinimumCacheDuration.Seconds())
 			}
 
+			cacheDuration := time.Duration(expirationSeconds) * time.Second
+
+			// store by its url+the key in order to be unique key among different servers with the same paths
+			s.set(key, statusCode, contentType, body, cacheDuration)
+			s.config.logger().Printf("httpcache: stored %s for %s", key, cacheDuration)
+
 			w.WriteHeader(cfg.SuccessStatus)
 		}
 	case methodDelete:
@@ -170,6 +356,7 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// manually DELETE cache should remove this entirely
 			// no just invalidate it
 			s.store.Remove(key)
+			s.release(func(k string) bool { return k == key })
 			w.WriteHeader(cfg.SuccessStatus)
 		}
 	default:
@@ -178,6 +365,20 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// purge removes all the entries, or the entries under the cfg.QueryCachePrefix url parameter,
+// and responds with the number of the removed entries,
+// the request should send the Config.PurgeToken as "Authorization: Bearer <PurgeToken>".
+func (s *Handler) purge(w http.ResponseWriter, r *http.Request) {
+	if s.config.PurgeToken == "" || !authorized(r, s.config.PurgeToken) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	n := s.InvalidatePrefix(getURLParam(r, cfg.QueryCachePrefix))
+	w.WriteHeader(cfg.SuccessStatus)
+	w.Write([]byte(strconv.Itoa(n)))
+}
+
 // the actual work is done on the handler.go
 // here we just provide a helper for the main package to create
 // an http.Server and serve a cache remote service, without any user touches
@@ -187,12 +388,16 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 //
 // it doesn't listens to the server
 func New(addr string, store Store) *http.Server {
-	if store == nil {
-		store = NewMemoryStore()
-	}
-	h := &Handler{store: store}
+	return NewWithConfig(addr, store, Config{})
+}
+
+// NewWithConfig same as New but it bounds the memory
+// which the clients can consume, see Config.
+//
+// it doesn't listens to the server
+func NewWithConfig(addr string, store Store, c Config) *http.Server {
 	return &http.Server{
 		Addr:    addr,
-		Handler: h,
+		Handler: NewHandlerWithConfig(store, c),
 	}
 }
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"sync/atomic"
+)
+
+// Stats are the counters of a Handler, see Handler.Stats.
+type Stats struct {
+	// Hits is the number of the clients' GET requests which found a valid entry.
+	Hits uint64
+	// Misses is the number of the clients' GET requests which found no valid entry.
+	Misses uint64
+	// Entries is the number of the valid entries.
+	Entries int
+	// Bytes is the total size of the valid entries' bodies.
+	Bytes int64
+	// Evictions is the number of the entries that the store has removed by itself,
+	// it's always zero if the store doesn't implement the EvictionCounter.
+	Evictions uint64
+}
+
+// Stats returns the current counters of the handler and its store,
+// it's safe to call it under concurrent traffic, i.e by a metrics collector.
+//
+// Note that the entries and the bytes are counted by visiting each one of the store's entries.
+func (s *Handler) Stats() Stats {
+	stats := Stats{
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+	}
+
+	if c, ok := s.store.(EvictionCounter); ok {
+		stats.Evictions = c.Evictions()
+	}
+
+	for _, key := range s.store.Keys() {
+		e := Peek(s.store, key)
+		if e == nil {
+			continue
+		}
+		if res, valid := e.Response(); valid {
+			stats.Entries++
+			stats.Bytes += int64(len(res.Body()))
+		}
+	}
+
+	return stats
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"math/rand"
 	"sync"
 	"time"
 
@@ -22,14 +23,130 @@ type (
 		// otherwise a silent update to the underline entry's
 		// Response is done
 		Remove(key string)
+		// Len returns the number of the entries, expired or not,
+		// the stores which can't count them cheaply can return a best-effort count.
+		Len() int
+		// Keys returns the raw cache keys of the entries, expired or not.
+		Keys() []string
+	}
+
+	// MatchingRemover is an optional interface of a Store
+	// which can remove all the entries that their keys are matching,
+	// it's used by the prefix invalidation, see Handler.InvalidatePrefix.
+	MatchingRemover interface {
+		// RemoveMatching removes the entries that their raw cache keys
+		// are matching the "match" func, returns the number of the removed entries.
+		RemoveMatching(match func(key string) bool) int
+	}
+
+	// EntrySetter is an optional interface of a Store
+	// which can save an entry as it's, with its lifetime and its response's metadata,
+	// i.e the validators. It's used by the local handlers which share a store.
+	EntrySetter interface {
+		// SetEntry adds, or replaces, the entry of the key.
+		SetEntry(key string, e *entry.Entry)
+	}
+
+	// CheckedSetter is an optional interface of a Store
+	// which reports the failures of its writes, i.e of a backend which is down or full,
+	// the local handlers log them. The bolt store implements it.
+	CheckedSetter interface {
+		// TrySetEntry adds, or replaces, the entry of the key,
+		// returns the error if it couldn't be saved.
+		TrySetEntry(key string, e *entry.Entry) error
+	}
+
+	// Generational is an optional interface of a Store
+	// which starts a new generation of entries on each clear,
+	// a set which has been scheduled before a clear, i.e by a cache miss which was in progress,
+	// is discarded, this way a cleared entry is not re-inserted by a late set.
+	// It's used by the local handlers which share a store.
+	Generational interface {
+		// Generation returns the current generation of the store.
+		Generation() uint64
+		// SetEntryIf adds, or replaces, the entry of the key
+		// only if the store's generation is still the "generation",
+		// returns false if it's discarded.
+		SetEntryIf(generation uint64, key string, e *entry.Entry) bool
+	}
+
+	// Swapper is an optional interface of a Store
+	// which replaces an entry only if it's still the stored one,
+	// it's used by the local handlers to save a touched or an extended copy of an entry,
+	// see Handler.SlidingExpiration, without overwriting a newer response. The builtin stores implement it.
+	Swapper interface {
+		// SwapEntry replaces the "old" entry of the key, as it was returned by Get, with the "e" one,
+		// returns false if the key holds another entry, or none, then nothing is changed.
+		// The OnSet hooks are not fired, it's the same response.
+		SwapEntry(key string, old, e *entry.Entry) bool
+	}
+
+	// Peeker is an optional interface of a Store
+	// which can read an entry without counting it as a use, i.e of the LFU memory store
+	// whose Get increases the entry's frequency. It's used by the scans of all the entries,
+	// i.e by the stats and the debug handler, see the Peek function.
+	Peeker interface {
+		// Peek returns the entry of the key, as Get does, without counting it as a use.
+		Peek(key string) *entry.Entry
+	}
+
+	// GarbageCollector is an optional interface of a Store
+	// which removes its expired entries with a background scan,
+	// the memory stores implement it, their scan is disabled by default.
+	// A store of a backend which expires its entries by itself, i.e redis, doesn't need it.
+	GarbageCollector interface {
+		// SetGCInterval sets the interval of the expired entries scan,
+		// a value <=0 disables it.
+		SetGCInterval(d time.Duration)
+	}
+
+	// ExpiredClearer is an optional interface of a Store
+	// which can remove its expired entries on demand, i.e before a heap snapshot or after a traffic spike,
+	// besides its background scan, see the ClearExpired function. The memory and the bolt stores implement it.
+	ExpiredClearer interface {
+		// ClearExpired removes the expired entries synchronously,
+		// returns the number of the removed entries.
+		ClearExpired() int
+	}
+
+	// Clearer is an optional interface of a Store
+	// which can remove all of its entries at once, see the Clear function.
+	// The memory store implements it.
+	Clearer interface {
+		// Clear removes all the entries.
+		Clear()
+	}
+
+	// ByteCounter is an optional interface of a Store
+	// which keeps the total size of its entries' bodies, expired or not, as they are stored.
+	// The LFU memory store implements it, see NewMemoryStoreLFU.
+	ByteCounter interface {
+		// Bytes returns the total size, in bytes, of the entries' bodies.
+		Bytes() int64
+	}
+
+	// MultiStore is an optional interface of a Store
+	// which can set and get many entries at once, i.e with a single transaction or a pipeline
+	// of a distributed backend. It's not used by the handlers, it's used by the warm-up
+	// and the batch tools through the SetMulti and GetMulti functions.
+	MultiStore interface {
+		// SetMulti adds, or replaces, the entries by their keys.
+		SetMulti(entries map[string]*entry.Entry)
+		// GetMulti returns the existing entries of the "keys", by their keys.
+		GetMulti(keys []string) map[string]*entry.Entry
 	}
 
 	// memoryStore keeps the cache bag, by default httpcache package provides one global default cache service  which provides these functions:
 	// `httpcache.Cache`, `httpcache.Invalidate` and `httpcache.Start`
 	// Store and NewStore used only when you want to have two different separate cache bags
 	memoryStore struct {
+		hooks
 		cache map[string]*entry.Entry
-		mu    sync.RWMutex
+		// generation is increased by each Clear, see Generational.
+		generation uint64
+		// gcStop stops the running expired entries scan, if any, see SetGCInterval.
+		gcStop chan struct{}
+		mu     sync.RWMutex
 	}
 )
 
@@ -51,6 +168,44 @@ func (s *memoryStore) Set(key string, statusCode int, contentType string, body [
 	s.mu.Lock()
 	s.cache[key] = e
 	s.mu.Unlock()
+	s.fireSet(key, e)
+}
+
+func (s *memoryStore) SetEntry(key string, e *entry.Entry) {
+	s.mu.Lock()
+	s.cache[key] = e
+	s.mu.Unlock()
+	s.fireSet(key, e)
+}
+
+func (s *memoryStore) Generation() uint64 {
+	s.mu.RLock()
+	generation := s.generation
+	s.mu.RUnlock()
+	return generation
+}
+
+func (s *memoryStore) SetEntryIf(generation uint64, key string, e *entry.Entry) bool {
+	s.mu.Lock()
+	if s.generation != generation {
+		// cleared in between.
+		s.mu.Unlock()
+		return false
+	}
+	s.cache[key] = e
+	s.mu.Unlock()
+	s.fireSet(key, e)
+	return true
+}
+
+func (s *memoryStore) SwapEntry(key string, old, e *entry.Entry) bool {
+	s.mu.Lock()
+	swapped := s.cache[key] == old
+	if swapped {
+		s.cache[key] = e
+	}
+	s.mu.Unlock()
+	return swapped
 }
 
 func (s *memoryStore) Get(key string) *entry.Entry {
@@ -69,10 +224,199 @@ func (s *memoryStore) Remove(key string) {
 	s.mu.Unlock()
 }
 
+func (s *memoryStore) RemoveMatching(match func(key string) bool) int {
+	n := 0
+	s.mu.Lock()
+	for k := range s.cache {
+		if match(k) {
+			delete(s.cache, k)
+			n++
+		}
+	}
+	s.mu.Unlock()
+	return n
+}
+
+func (s *memoryStore) Len() int {
+	s.mu.RLock()
+	n := len(s.cache)
+	s.mu.RUnlock()
+	return n
+}
+
+func (s *memoryStore) Keys() []string {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.cache))
+	for k := range s.cache {
+		keys = append(keys, k)
+	}
+	s.mu.RUnlock()
+	return keys
+}
+
+// Clear removes all the entries and starts a new generation,
+// the sets of the previous generation are discarded, see Generational.
 func (s *memoryStore) Clear() {
 	s.mu.Lock()
+	s.generation++
 	for k := range s.cache {
 		delete(s.cache, k)
 	}
 	s.mu.Unlock()
 }
+
+// SetGCInterval sets the interval of the expired entries scan, a value <=0 disables it,
+// the entries which can still be served as stale are not removed.
+func (s *memoryStore) SetGCInterval(d time.Duration) {
+	s.mu.Lock()
+	if s.gcStop != nil {
+		close(s.gcStop)
+		s.gcStop = nil
+	}
+	if d > 0 {
+		s.gcStop = make(chan struct{})
+		go s.startGC(d, s.gcStop)
+	}
+	s.mu.Unlock()
+}
+
+// gcJitterDivisor divides the GC interval to the maximum deviation of each GC tick,
+// i.e 10 means up to 10% earlier or later, the stores with the same interval don't sweep together.
+const gcJitterDivisor = 10
+
+// gcJitter returns the "d" GC interval with a random deviation, see gcJitterDivisor.
+func gcJitter(d time.Duration) time.Duration {
+	max := int64(d) / gcJitterDivisor
+	if max <= 0 {
+		return d
+	}
+	return d - time.Duration(max) + time.Duration(rand.Int63n(2*max+1))
+}
+
+func (s *memoryStore) startGC(gcDuration time.Duration, stop chan struct{}) {
+	timer := time.NewTimer(gcJitter(gcDuration))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+			s.removeExpired()
+			timer.Reset(gcJitter(gcDuration))
+		}
+	}
+}
+
+// ClearExpired removes the expired entries right now, except the ones which can still be served as stale,
+// returns the number of the removed entries.
+func (s *memoryStore) ClearExpired() int {
+	return s.removeExpired()
+}
+
+// removeExpired removes the expired entries, except the ones which can still be served as stale.
+func (s *memoryStore) removeExpired() int {
+	evicted := make(map[string]*entry.Entry)
+	s.mu.Lock()
+	for k, e := range s.cache {
+		if _, valid := e.Response(); valid {
+			continue
+		}
+		if _, stale := e.Stale(); stale {
+			continue
+		}
+		evicted[k] = e
+		delete(s.cache, k)
+	}
+	s.mu.Unlock()
+	s.fireEvict(evicted)
+	return len(evicted)
+}
+
+// Clear removes all the entries of the "store",
+// at once if the store is a Clearer, otherwise one by one.
+func Clear(store Store) {
+	if c, ok := store.(Clearer); ok {
+		c.Clear()
+		return
+	}
+
+	for _, key := range store.Keys() {
+		store.Remove(key)
+	}
+}
+
+// ClearExpired removes the expired entries of the "store" right now and returns their number,
+// with a single call if the store is an ExpiredClearer, otherwise one by one,
+// the entries which can still be served as stale are kept.
+func ClearExpired(store Store) int {
+	if c, ok := store.(ExpiredClearer); ok {
+		return c.ClearExpired()
+	}
+
+	n := 0
+	for _, key := range store.Keys() {
+		e := Peek(store, key)
+		if e == nil {
+			continue
+		}
+		if _, valid := e.Response(); valid {
+			continue
+		}
+		if _, stale := e.Stale(); stale {
+			continue
+		}
+		store.Remove(key)
+		n++
+	}
+	return n
+}
+
+// Peek returns the entry of the "key" from the "store" without counting it as a use
+// if the store is a Peeker, otherwise it's the store's Get.
+func Peek(store Store, key string) *entry.Entry {
+	if p, ok := store.(Peeker); ok {
+		return p.Peek(key)
+	}
+	return store.Get(key)
+}
+
+// SetMulti adds, or replaces, the "entries" to the "store" by their keys,
+// with a single call if the store is a MultiStore, otherwise one by one.
+// The expired entries are skipped by the stores which are not EntrySetters.
+func SetMulti(store Store, entries map[string]*entry.Entry) {
+	if m, ok := store.(MultiStore); ok {
+		m.SetMulti(entries)
+		return
+	}
+
+	setter, isSetter := store.(EntrySetter)
+	for key, e := range entries {
+		if isSetter {
+			setter.SetEntry(key, e)
+			continue
+		}
+
+		res, ok := e.Response()
+		if !ok {
+			continue
+		}
+		store.Set(key, res.StatusCode(), res.ContentType(), res.Body(), time.Until(e.ExpiresAt()))
+	}
+}
+
+// GetMulti returns the existing entries of the "keys" from the "store", by their keys,
+// with a single call if the store is a MultiStore, otherwise one by one.
+func GetMulti(store Store, keys []string) map[string]*entry.Entry {
+	if m, ok := store.(MultiStore); ok {
+		return m.GetMulti(keys)
+	}
+
+	entries := make(map[string]*entry.Entry, len(keys))
+	for _, key := range keys {
+		if e := store.Get(key); e != nil {
+			entries[key] = e
+		}
+	}
+	return entries
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/geekypanda/httpcache/entry"
+)
+
+// syncMapStore is a memory store which keeps its entries to a sync.Map,
+// its Get doesn't lock, see NewSyncMapStore.
+type syncMapStore struct {
+	hooks
+	cache sync.Map // map[string]*entry.Entry
+
+	// gcStop stops the running expired entries scan, if any, see SetGCInterval.
+	gcStop chan struct{}
+	gcMu   sync.Mutex
+}
+
+// NewSyncMapStore returns a new memory store which keeps its entries to a sync.Map,
+// it's an alternative of the NewMemoryStore for the read-heavy workloads,
+// its Get doesn't contend on a lock, but its Set and its Len are slower.
+//
+// "gcDuration" is the interval of the background scan which removes the expired entries,
+// a value <=0 disables the scan.
+//
+// The returned Store implements the GarbageCollector, which changes the scan's interval,
+// and the io.Closer too, which stops the scan.
+func NewSyncMapStore(gcDuration time.Duration) Store {
+	s := &syncMapStore{}
+	s.SetGCInterval(gcDuration)
+	return s
+}
+
+func (s *syncMapStore) Set(key string, statusCode int, contentType string, body []byte, expiration time.Duration) {
+	e := entry.NewEntry(expiration)
+	e.Reset(statusCode, contentType, body, nil)
+	s.SetEntry(key, e)
+}
+
+func (s *syncMapStore) SetEntry(key string, e *entry.Entry) {
+	s.cache.Store(key, e)
+	s.fireSet(key, e)
+}
+
+func (s *syncMapStore) SwapEntry(key string, old, e *entry.Entry) bool {
+	return s.cache.CompareAndSwap(key, old, e)
+}
+
+func (s *syncMapStore) Get(key string) *entry.Entry {
+	if v, ok := s.cache.Load(key); ok {
+		return v.(*entry.Entry)
+	}
+	return nil
+}
+
+func (s *syncMapStore) Remove(key string) {
+	s.cache.Delete(key)
+}
+
+func (s *syncMapStore) RemoveMatching(match func(key string) bool) int {
+	n := 0
+	s.cache.Range(func(k, _ interface{}) bool {
+		if key := k.(string); match(key) {
+			s.cache.Delete(key)
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// Len returns the number of the entries, it visits each one of them.
+func (s *syncMapStore) Len() int {
+	n := 0
+	s.cache.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (s *syncMapStore) Keys() []string {
+	var keys []string
+	s.cache.Range(func(k, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	return keys
+}
+
+// SetGCInterval sets the interval of the expired entries scan, a value <=0 disables it,
+// the entries which can still be served as stale are not removed.
+func (s *syncMapStore) SetGCInterval(d time.Duration) {
+	s.gcMu.Lock()
+	if s.gcStop != nil {
+		close(s.gcStop)
+		s.gcStop = nil
+	}
+	if d > 0 {
+		s.gcStop = make(chan struct{})
+		go s.startGC(d, s.gcStop)
+	}
+	s.gcMu.Unlock()
+}
+
+// Close stops the expired entries scan.
+func (s *syncMapStore) Close() error {
+	s.SetGCInterval(0)
+	return nil
+}
+
+func (s *syncMapStore) startGC(gcDuration time.Duration, stop chan struct{}) {
+	timer := time.NewTimer(gcJitter(gcDuration))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+			s.removeExpired()
+			timer.Reset(gcJitter(gcDuration))
+		}
+	}
+}
+
+// ClearExpired removes the expired entries right now, except the ones which can still be served as stale,
+// returns the number of the removed entries.
+func (s *syncMapStore) ClearExpired() int {
+	return s.removeExpired()
+}
+
+// removeExpired removes the expired entries, except the ones which can still be served as stale.
+func (s *syncMapStore) removeExpired() int {
+	evicted := make(map[string]*entry.Entry)
+	s.cache.Range(func(k, v interface{}) bool {
+		e := v.(*entry.Entry)
+		if _, valid := e.Response(); valid {
+			return true
+		}
+		if _, stale := e.Stale(); stale {
+			return true
+		}
+		// don't remove an entry which has been renewed in the meantime.
+		if s.cache.CompareAndDelete(k, v) {
+			evicted[k.(string)] = e
+		}
+		return true
+	})
+	s.fireEvict(evicted)
+	return len(evicted)
+}
@@ -0,0 +1,73 @@
+package uri
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultRingReplicas is the default number of the virtual points of each address on a Ring.
+const DefaultRingReplicas = 100
+
+// Ring is a consistent hashing ring of the remote cache servers' addresses,
+// it routes each cache key to one of them, see Get.
+// When an address is added or removed, only the keys of its own slice move,
+// the rest keep their addresses.
+//
+// A Ring is immutable, it's safe for concurrent use.
+type Ring struct {
+	points []uint32
+	addrs  map[uint32]string
+}
+
+// NewRing returns a new Ring of the "addrs",
+// each address is placed on the ring "replicas" times to distribute the keys evenly,
+// a "replicas" <=0 means the DefaultRingReplicas.
+// The duplicated addresses are placed once, the order of the "addrs" doesn't matter.
+func NewRing(addrs []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultRingReplicas
+	}
+
+	r := &Ring{addrs: make(map[uint32]string)}
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(addr + "#" + strconv.Itoa(i)))
+			if existing, exists := r.addrs[point]; exists {
+				// a collision, the smallest address keeps the point,
+				// whatever the order of the "addrs" is.
+				if addr < existing {
+					r.addrs[point] = addr
+				}
+				continue
+			}
+			r.addrs[point] = addr
+			r.points = append(r.points, point)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Get returns the address of the "key",
+// the first one clockwise of the key's point on the ring.
+// Returns an empty string if the ring has no addresses.
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	point := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= point })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.addrs[r.points[i]]
+}
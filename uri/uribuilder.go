@@ -87,7 +87,7 @@ func (r URIBuilder) build() string {
 		if strings.Contains(remoteURL, ":443") || strings.Contains(remoteURL, ":https") {
 			remoteURL = "https://" + remoteURL
 		} else {
-			remoteURL = scheme + "://" + remoteURL
+			remoteURL = scheme + remoteURL
 		}
 	}
 	var cacheDurationStr, statusCodeStr string
@@ -100,6 +100,8 @@ func (r URIBuilder) build() string {
 		statusCodeStr = strconv.Itoa(r.cacheStatuscode)
 	}
 
+	// the client uri is already escaped, it's escaped once more as a whole,
+	// the server's url query parsing unescapes it back, so the key is the same on each method.
 	s := remoteURL + "?" + cfg.QueryCacheKey + "=" + url.QueryEscape(r.clientMethod+scheme+r.clientURI)
 	if cacheDurationStr != "" {
 		s += "&" + cfg.QueryCacheDuration + "=" + url.QueryEscape(cacheDurationStr)
@@ -112,3 +114,86 @@ func (r URIBuilder) build() string {
 	}
 	return s
 }
+
+// FilterQuery returns the "rawQuery" with only the "include" parameters, if any,
+// and without the "exclude" ones, it's used to build the cache keys
+// when only some of the query parameters should participate in the key.
+// The result is encoded and sorted by parameter name,
+// if both "include" and "exclude" are empty then the "rawQuery" is returned as it's.
+func FilterQuery(rawQuery string, include, exclude []string) string {
+	if rawQuery == "" || (len(include) == 0 && len(exclude) == 0) {
+		return rawQuery
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+
+	if len(include) > 0 {
+		included := make(url.Values, len(include))
+		for _, k := range include {
+			if v, ok := values[k]; ok {
+				included[k] = v
+			}
+		}
+		values = included
+	}
+
+	for _, k := range exclude {
+		delete(values, k)
+	}
+
+	return values.Encode()
+}
+
+// NormalizeKey is a cache key normalizer which lowercases the path of the "key"
+// and strips its trailing slashes, i.e "/Path/?q=Go" becomes "/path?q=Go",
+// the query is kept as it's.
+// This way the routes which treat these paths identically share the same cached response.
+func NormalizeKey(key string) string {
+	path, query := key, ""
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		path, query = key[:i], key[i:]
+	}
+
+	path = strings.ToLower(path)
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		path = trimmed
+	} else {
+		path = "/"
+	}
+	return path + query
+}
+
+// TTLPatterns maps request path patterns to cache lifetimes,
+// i.e {"/static/*": time.Hour, "/api/*": 30 * time.Second}, see Match.
+type TTLPatterns map[string]time.Duration
+
+// Match returns the lifetime of the pattern which matches the "path",
+// a pattern which ends with "*" matches the paths that start with it, i.e "/static/*" matches "/static/css/main.css",
+// otherwise it matches the exact path only.
+// If more than one patterns match then the longest one wins, an exact pattern wins a wildcard one of the same length.
+//
+// Returns false if no pattern matches the "path".
+func (p TTLPatterns) Match(path string) (time.Duration, bool) {
+	var (
+		ttl     time.Duration
+		longest = -1
+		exact   bool
+	)
+
+	for pattern, d := range p {
+		prefix := strings.TrimSuffix(pattern, "*")
+		isExact := prefix == pattern
+		if isExact && path != pattern || !isExact && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		if len(prefix) > longest || (len(prefix) == longest && isExact && !exact) {
+			ttl, longest, exact = d, len(prefix), isExact
+		}
+	}
+
+	return ttl, longest >= 0
+}